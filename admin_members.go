@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Bulk actions on the admin members table
+const (
+	bulkActionMarkPaid   = "mark_paid"
+	bulkActionMarkUnpaid = "mark_unpaid"
+	bulkActionAssignRole = "assign_role"
+	bulkActionSendEmail  = "send_email"
+)
+
+// GetUsersByIDs retrieves the users with the given StravaIDs, sorted by first name
+func GetUsersByIDs(ctx context.Context, stravaIDs []int64) ([]User, error) {
+	return findUsers(ctx, bson.M{"stravaID": bson.M{"$in": stravaIDs}})
+}
+
+// adminMembersHandler shows the members table with search and bulk actions
+func adminMembersHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	members, err := SearchUsers(r.Context(), r.FormValue("q"), r.FormValue("paid"))
+	if err != nil {
+		log.Printf("Error searching members for admin table: %v", err)
+		http.Error(w, "Failed to load members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Members:     members,
+		Tiers:       allTiers(),
+		Roles:       grantableRoles(),
+		CSSVersion:  cssVersion,
+
+		EmailEnabled: mailer != nil,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_members.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_members template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminMembersSearchHandler handles HTMX searches, returning just the members table
+func adminMembersSearchHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	members, err := SearchUsers(r.Context(), r.FormValue("q"), r.FormValue("paid"))
+	if err != nil {
+		log.Printf("Error searching members for admin table: %v", err)
+		http.Error(w, "Failed to search members", http.StatusInternalServerError)
+		return
+	}
+
+	renderMembersTable(w, TemplateData{User: user, IsAdmin: user.IsAdmin, Members: members})
+}
+
+// adminBulkMembersHandler applies a bulk action to the selected members. Each action is a single
+// update in the store layer and is recorded as one audit entry.
+func adminBulkMembersHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var ids []int64
+	for _, value := range r.Form["userIDs"] {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		http.Error(w, "Select at least one member", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	selected, err := GetUsersByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("Error fetching selected members for bulk action: %v", err)
+		http.Error(w, "Failed to load selected members", http.StatusInternalServerError)
+		return
+	}
+
+	action := r.FormValue("action")
+	before := make(map[string]string, len(selected))
+	after := map[string]string{"action": action}
+	var notice string
+
+	switch action {
+	case bulkActionMarkPaid:
+		season, err := strconv.Atoi(r.FormValue("season"))
+		if err != nil {
+			http.Error(w, "Invalid season", http.StatusBadRequest)
+			return
+		}
+		tier := tierByKey(r.FormValue("tier"))
+		if tier == nil {
+			http.Error(w, "Unknown membership tier", http.StatusBadRequest)
+			return
+		}
+		for _, member := range selected {
+			before[strconv.FormatInt(member.StravaID, 10)] = paidSummary(&member, season)
+		}
+		m := newSeasonMembership(season, tier.Key, tier.PricePence, user.StravaID)
+		added, err := AddSeasonMemberships(ctx, ids, m)
+		if err != nil {
+			log.Printf("Error marking members paid in bulk: %v", err)
+			http.Error(w, "Failed to mark members paid", http.StatusInternalServerError)
+			return
+		}
+		after["season"] = strconv.Itoa(season)
+		after["tier"] = tier.Key
+		notice = fmt.Sprintf("Marked %d of %d selected members paid for %d (%s).", added, len(selected), season, tier.Name)
+
+	case bulkActionMarkUnpaid:
+		season := seasonFor(time.Now())
+		for _, member := range selected {
+			before[strconv.FormatInt(member.StravaID, 10)] = paidSummary(&member, season)
+		}
+		ended, err := EndCurrentMembershipsForUsers(ctx, ids)
+		if err != nil {
+			log.Printf("Error marking members unpaid in bulk: %v", err)
+			http.Error(w, "Failed to mark members unpaid", http.StatusInternalServerError)
+			return
+		}
+		notice = fmt.Sprintf("Ended the memberships of %d of %d selected members.", ended, len(selected))
+
+	case bulkActionAssignRole:
+		if !user.Can(permManageRoles) {
+			http.Error(w, "Only admins can assign roles", http.StatusForbidden)
+			return
+		}
+		role := roleByKey(r.FormValue("role"))
+		if role == nil || role.Key == roleMember {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+		for _, member := range selected {
+			before[strconv.FormatInt(member.StravaID, 10)] = roleList(member.Roles)
+		}
+		granted, err := GrantRoleToUsers(ctx, ids, role.Key)
+		if err != nil {
+			log.Printf("Error assigning role %s in bulk: %v", role.Key, err)
+			http.Error(w, "Failed to assign role", http.StatusInternalServerError)
+			return
+		}
+		after["role"] = role.Key
+		notice = fmt.Sprintf("Gave the %s role to %d of %d selected members.", role.Name, granted, len(selected))
+
+	case bulkActionSendEmail:
+		if mailer == nil {
+			http.Error(w, "Email is not configured", http.StatusBadRequest)
+			return
+		}
+		subject := strings.TrimSpace(r.FormValue("subject"))
+		message := strings.TrimSpace(r.FormValue("message"))
+		if subject == "" || message == "" {
+			http.Error(w, "Enter a subject and a message", http.StatusBadRequest)
+			return
+		}
+		queued := 0
+		for i := range selected {
+			member := &selected[i]
+			if member.Email == "" {
+				continue
+			}
+			if err := queueNotification(ctx, member, notifyAnnouncement, "", subject, EmailData{Message: message}); err != nil {
+				log.Printf("Error queueing email to user %d: %v", member.StravaID, err)
+				continue
+			}
+			queued++
+		}
+		after["subject"] = subject
+		notice = fmt.Sprintf("Queued an email to %d of %d selected members. Members without a confirmed address are skipped.", queued, len(selected))
+
+	default:
+		http.Error(w, "Choose a bulk action", http.StatusBadRequest)
+		return
+	}
+
+	targetIDs := make([]string, len(selected))
+	for i, member := range selected {
+		targetIDs[i] = strconv.FormatInt(member.StravaID, 10)
+	}
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionBulkUpdate,
+		TargetID:  strings.Join(targetIDs, ","),
+		Details:   notice,
+		Before:    before,
+		After:     after,
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for bulk %s: %v", action, err)
+	}
+	log.Printf("Bulk %s by %s: %s", action, user.FirstName, notice)
+
+	members, err := SearchUsers(ctx, r.FormValue("q"), r.FormValue("paid"))
+	if err != nil {
+		log.Printf("Error searching members after bulk action: %v", err)
+		http.Error(w, "Failed to load updated members list", http.StatusInternalServerError)
+		return
+	}
+
+	renderMembersTable(w, TemplateData{User: user, IsAdmin: user.IsAdmin, Members: members, Notice: notice})
+}
+
+// paidSummary describes a member's paid status for a bulk action's audit entry
+func paidSummary(u *User, season int) string {
+	status := "unpaid"
+	if u.IsPaidMember {
+		status = "paid"
+	}
+	if m := u.MembershipForSeason(season, time.Now()); m != nil {
+		return fmt.Sprintf("%s, %s for %d", status, m.Type, season)
+	}
+	return status
+}
+
+// renderMembersTable renders the admin members table fragment for an HTMX swap
+func renderMembersTable(w http.ResponseWriter, data TemplateData) {
+	w.Header().Set("Content-Type", "text/html")
+	err := tmpl.ExecuteTemplate(w, "members_table_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing members_table_fragment template: %v", err)
+		http.Error(w, "Failed to render members table", http.StatusInternalServerError)
+	}
+}
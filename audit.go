@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records an administrative or destructive action. The audit collection is
+// append-only: entries are inserted by RecordAudit and never updated or deleted, including
+// when the actor or target deletes their account.
+type AuditEntry struct {
+	ID        string            `bson:"_id,omitempty"`
+	ActorID   int64             `bson:"actorID"`   // StravaID of the user who performed the action
+	ActorName string            `bson:"actorName"` // Name at the time of the action, kept even if the actor is deleted
+	Action    string            `bson:"action"`
+	TargetID  string            `bson:"targetID"` // StravaID, route ID or tier key the action applied to
+	Details   string            `bson:"details"`
+	Before    map[string]string `bson:"before,omitempty"` // Values the action changed, as they were
+	After     map[string]string `bson:"after,omitempty"`  // and as they became
+	Timestamp time.Time         `bson:"timestamp"`
+}
+
+const (
+	auditCollection = "audit" // MongoDB collection name
+	auditPageSize   = 200     // Entries per page of the audit page; the CSV export has no limit
+	auditDateLayout = "2006-01-02"
+)
+
+// Audit actions
+const (
+	auditActionAccountDeleted  = "account.deleted"
+	auditActionDataExported    = "member.data_exported"
+	auditActionMembershipPaid  = "membership.paid_online"
+	auditActionTierSaved       = "tier.saved"
+	auditActionRolesChanged    = "member.roles_changed"
+	auditActionPaidToggled     = "membership.paid_toggled"
+	auditActionRenewalAdded    = "membership.renewal_recorded"
+	auditActionRouteDeleted    = "route.deleted"
+	auditActionRouteEdited     = "route.reclassified"
+	auditActionRouteReviewed   = "route.reviewed"
+	auditActionRouteRestored   = "route.restored"
+	auditActionBulkUpdate      = "members.bulk_updated"
+	auditActionRosterImported  = "members.roster_imported"
+	auditActionRideSheetViewed = "ride.sheet_viewed"
+	auditActionTrashRestored   = "trash.restored"
+	auditActionTrashPurged     = "trash.purged"
+)
+
+// RecordAudit appends an entry to the audit collection
+func RecordAudit(ctx context.Context, entry *AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	res, err := mongoDB.Collection(auditCollection).InsertOne(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry %q: %w", entry.Action, err)
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		entry.ID = oid.Hex()
+	}
+	return nil
+}
+
+// GetAuditEntriesForUser retrieves audit entries where the user is either the actor or the target
+func GetAuditEntriesForUser(ctx context.Context, stravaID int64) ([]AuditEntry, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"actorID": stravaID},
+		bson.M{"targetID": strconv.FormatInt(stravaID, 10)},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := mongoDB.Collection(auditCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding audit entries for user %d: %w", stravaID, err)
+	}
+	defer cursor.Close(ctx)
+
+	return decodeAuditEntries(ctx, cursor)
+}
+
+// decodeAuditEntries reads audit entries from a cursor, keeping their IDs as hex strings
+func decodeAuditEntries(ctx context.Context, cursor *mongo.Cursor) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for cursor.Next(ctx) {
+		var entry AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("error decoding audit entry: %w", err)
+		}
+		if oid, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			entry.ID = oid.Hex()
+		}
+		entries = append(entries, entry)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return entries, nil
+}
+
+// AuditFilter narrows the audit log; zero fields match everything
+type AuditFilter struct {
+	Action   string
+	ActorID  int64
+	TargetID string
+	From     time.Time // Inclusive
+	To       time.Time // Inclusive, the whole day
+	Page     int       // Page of the audit page, from 1; the CSV export ignores it
+}
+
+// auditFilterFromRequest reads an AuditFilter from the audit page's query string
+func auditFilterFromRequest(r *http.Request) (AuditFilter, error) {
+	q := r.URL.Query()
+	filter := AuditFilter{
+		Action:   strings.TrimSpace(q.Get("action")),
+		TargetID: strings.TrimSpace(q.Get("target")),
+		Page:     1,
+	}
+	if actor := strings.TrimSpace(q.Get("actor")); actor != "" {
+		id, err := strconv.ParseInt(actor, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid actor ID")
+		}
+		filter.ActorID = id
+	}
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(auditDateLayout, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date")
+		}
+		filter.From = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(auditDateLayout, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date")
+		}
+		filter.To = t
+	}
+	if page := q.Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return filter, fmt.Errorf("invalid page")
+		}
+		filter.Page = n
+	}
+	return filter, nil
+}
+
+// QueryString encodes the filter without its page for links, e.g. the CSV download
+func (f AuditFilter) QueryString() string {
+	return f.values().Encode()
+}
+
+// NewerQueryString and OlderQueryString encode the filter for the previous and next pages
+func (f AuditFilter) NewerQueryString() string { return f.pageQueryString(f.Page - 1) }
+func (f AuditFilter) OlderQueryString() string { return f.pageQueryString(f.Page + 1) }
+
+func (f AuditFilter) pageQueryString(page int) string {
+	q := f.values()
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return q.Encode()
+}
+
+// values encodes the filter's criteria
+func (f AuditFilter) values() url.Values {
+	q := url.Values{}
+	if f.Action != "" {
+		q.Set("action", f.Action)
+	}
+	if f.ActorID != 0 {
+		q.Set("actor", strconv.FormatInt(f.ActorID, 10))
+	}
+	if f.TargetID != "" {
+		q.Set("target", f.TargetID)
+	}
+	if !f.From.IsZero() {
+		q.Set("from", f.From.Format(auditDateLayout))
+	}
+	if !f.To.IsZero() {
+		q.Set("to", f.To.Format(auditDateLayout))
+	}
+	return q
+}
+
+// FromValue and ToValue format the date range for the filter form
+func (f AuditFilter) FromValue() string { return formatAuditDate(f.From) }
+func (f AuditFilter) ToValue() string   { return formatAuditDate(f.To) }
+
+func formatAuditDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(auditDateLayout)
+}
+
+// bson builds the MongoDB query for the filter
+func (f AuditFilter) bson() bson.M {
+	query := bson.M{}
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+	if f.ActorID != 0 {
+		query["actorID"] = f.ActorID
+	}
+	if f.TargetID != "" {
+		query["targetID"] = f.TargetID
+	}
+	timestamp := bson.M{}
+	if !f.From.IsZero() {
+		timestamp["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timestamp["$lt"] = f.To.AddDate(0, 0, 1)
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+	return query
+}
+
+// QueryAuditEntries returns matching entries, newest first, skipping the first skip. A limit of 0
+// returns them all.
+func QueryAuditEntries(ctx context.Context, filter AuditFilter, skip, limit int64) ([]AuditEntry, error) {
+	// The _id breaks ties between entries recorded in the same millisecond, so pages don't overlap
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).SetSkip(skip)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := mongoDB.Collection(auditCollection).Find(ctx, filter.bson(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit entries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	return decodeAuditEntries(ctx, cursor)
+}
+
+// QueryAuditPage returns the filter's page of matching entries, newest first, and whether there
+// are older entries on later pages
+func QueryAuditPage(ctx context.Context, filter AuditFilter, pageSize int) ([]AuditEntry, bool, error) {
+	skip, limit := auditPageBounds(filter.Page, pageSize)
+	entries, err := QueryAuditEntries(ctx, filter, skip, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	entries, hasMore := trimAuditPage(entries, pageSize)
+	return entries, hasMore, nil
+}
+
+// auditPageBounds is how many entries come before the page, and how many to load for it: one
+// more than fit, to tell whether there's another page
+func auditPageBounds(page, pageSize int) (skip, limit int64) {
+	if page < 1 {
+		page = 1
+	}
+	return int64(page-1) * int64(pageSize), int64(pageSize) + 1
+}
+
+// trimAuditPage drops the extra entry loaded by auditPageBounds, reporting whether there was one
+func trimAuditPage(entries []AuditEntry, pageSize int) ([]AuditEntry, bool) {
+	if len(entries) > pageSize {
+		return entries[:pageSize], true
+	}
+	return entries, false
+}
+
+// GetAuditActions lists the distinct actions recorded, for the filter dropdown
+func GetAuditActions(ctx context.Context) ([]string, error) {
+	values, err := mongoDB.Collection(auditCollection).Distinct(ctx, "action", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing audit actions: %w", err)
+	}
+	var actions []string
+	for _, v := range values {
+		if action, ok := v.(string); ok {
+			actions = append(actions, action)
+		}
+	}
+	sort.Strings(actions)
+	return actions, nil
+}
+
+// formatAuditValues flattens before/after values for display and CSV, e.g. "classify=Sunday; name=Loop"
+func formatAuditValues(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+values[k])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// BeforeText and AfterText format the changed values for the audit page
+func (e AuditEntry) BeforeText() string { return formatAuditValues(e.Before) }
+func (e AuditEntry) AfterText() string  { return formatAuditValues(e.After) }
+
+// csvSafe stops spreadsheet apps treating a member-supplied value (e.g. a name from Strava) as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// adminAuditHandler shows the audit log, filtered by the query string
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permViewAudit) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := auditFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	entries, hasMore, err := QueryAuditPage(ctx, filter, auditPageSize)
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+	actions, err := GetAuditActions(ctx)
+	if err != nil {
+		log.Printf("Error listing audit actions: %v", err)
+	}
+
+	data := TemplateData{
+		Location:     "Borrowash, Derbyshire",
+		CurrentYear:  time.Now().Year(),
+		IsLoggedIn:   true,
+		User:         user,
+		IsAdmin:      user.IsAdmin,
+		AuditEntries: entries,
+		AuditHasMore: hasMore,
+		AuditFilter:  &filter,
+		AuditActions: actions,
+		CSSVersion:   cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_audit.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_audit template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminAuditExportHandler downloads the filtered audit log as CSV
+func adminAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permViewAudit) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := auditFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := QueryAuditEntries(r.Context(), filter, 0, 0)
+	if err != nil {
+		log.Printf("Error querying audit log for export: %v", err)
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("southpeakscc-audit-%s.csv", time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "action", "actor_id", "actor_name", "target_id", "details", "before", "after"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Timestamp.UTC().Format(time.RFC3339),
+			e.Action,
+			strconv.FormatInt(e.ActorID, 10),
+			csvSafe(e.ActorName),
+			csvSafe(e.TargetID),
+			csvSafe(e.Details),
+			csvSafe(e.BeforeText()),
+			csvSafe(e.AfterText()),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error writing audit CSV: %v", err)
+	}
+}
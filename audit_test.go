@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAuditFilterFromRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantPage int
+		wantErr  bool
+	}{
+		{"no page", "action=tier.saved", 1, false},
+		{"first page", "page=1", 1, false},
+		{"later page", "actor=42&page=3", 3, false},
+		{"zero page", "page=0", 0, true},
+		{"negative page", "page=-2", 0, true},
+		{"not a number", "page=next", 0, true},
+		{"invalid actor", "actor=bob", 0, true},
+		{"invalid date", "from=01/02/2025", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/admin/audit?"+tt.query, nil)
+			filter, err := auditFilterFromRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("auditFilterFromRequest error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && filter.Page != tt.wantPage {
+				t.Errorf("Page = %d, want %d", filter.Page, tt.wantPage)
+			}
+		})
+	}
+}
+
+func TestAuditFilterQueryStrings(t *testing.T) {
+	filter := AuditFilter{
+		Action: auditActionTierSaved,
+		From:   time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		Page:   2,
+	}
+
+	// The CSV covers every page, so its link leaves the page out
+	if got, want := filter.QueryString(), "action=tier.saved&from=2025-03-01"; got != want {
+		t.Errorf("QueryString() = %q, want %q", got, want)
+	}
+	if got, want := filter.NewerQueryString(), "action=tier.saved&from=2025-03-01"; got != want {
+		t.Errorf("NewerQueryString() = %q, want %q", got, want)
+	}
+	if got, want := filter.OlderQueryString(), "action=tier.saved&from=2025-03-01&page=3"; got != want {
+		t.Errorf("OlderQueryString() = %q, want %q", got, want)
+	}
+
+	// A page's links read back as the neighbouring pages with the same criteria
+	r := httptest.NewRequest("GET", "/admin/audit?"+filter.OlderQueryString(), nil)
+	older, err := auditFilterFromRequest(r)
+	if err != nil {
+		t.Fatalf("auditFilterFromRequest: %v", err)
+	}
+	if older.Page != 3 || older.Action != filter.Action || !older.From.Equal(filter.From) {
+		t.Errorf("older page filter = %+v, want page 3 of %+v", older, filter)
+	}
+}
+
+func TestAuditFilterBSONIncludesWholeToDay(t *testing.T) {
+	filter := AuditFilter{
+		From: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		Page: 4,
+	}
+	query := filter.bson()
+	if _, ok := query["page"]; ok {
+		t.Errorf("query %v includes the page", query)
+	}
+	timestamp, ok := query["timestamp"].(bson.M)
+	if !ok {
+		t.Fatalf("query %v has no timestamp range", query)
+	}
+	if got := timestamp["$gte"]; got != filter.From {
+		t.Errorf("$gte = %v, want %v", got, filter.From)
+	}
+	if got, want := timestamp["$lt"], time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC); got != want {
+		t.Errorf("$lt = %v, want %v", got, want)
+	}
+}
+
+func TestAuditPageBounds(t *testing.T) {
+	tests := []struct {
+		page      int
+		wantSkip  int64
+		wantLimit int64
+	}{
+		{0, 0, 201},
+		{1, 0, 201},
+		{2, 200, 201},
+		{5, 800, 201},
+	}
+	for _, tt := range tests {
+		skip, limit := auditPageBounds(tt.page, 200)
+		if skip != tt.wantSkip || limit != tt.wantLimit {
+			t.Errorf("auditPageBounds(%d, 200) = %d, %d, want %d, %d", tt.page, skip, limit, tt.wantSkip, tt.wantLimit)
+		}
+	}
+}
+
+func TestTrimAuditPage(t *testing.T) {
+	entries := make([]AuditEntry, 4)
+	tests := []struct {
+		loaded      int
+		wantLen     int
+		wantHasMore bool
+	}{
+		{0, 0, false},
+		{2, 2, false},
+		{3, 3, false},
+		{4, 3, true},
+	}
+	for _, tt := range tests {
+		page, hasMore := trimAuditPage(entries[:tt.loaded], 3)
+		if len(page) != tt.wantLen || hasMore != tt.wantHasMore {
+			t.Errorf("trimAuditPage(%d entries, 3) = %d entries, %v, want %d, %v", tt.loaded, len(page), hasMore, tt.wantLen, tt.wantHasMore)
+		}
+	}
+}
+
+func TestQueryAuditPage(t *testing.T) {
+	ctx := useTestMongo(t)
+
+	// Several entries share a timestamp, so the order must not depend on it alone
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	const total = 7
+	for i := 0; i < total; i++ {
+		entry := &AuditEntry{
+			Timestamp: start.Add(time.Duration(i/2) * time.Minute),
+			Action:    auditActionTierSaved,
+			TargetID:  fmt.Sprint(i),
+		}
+		if err := RecordAudit(ctx, entry); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+	if err := RecordAudit(ctx, &AuditEntry{Timestamp: start, Action: auditActionRouteDeleted}); err != nil {
+		t.Fatalf("RecordAudit: %v", err)
+	}
+
+	seen := map[string]bool{}
+	var last time.Time
+	for page, wantLen := range []int{3, 3, 1} {
+		filter := AuditFilter{Action: auditActionTierSaved, Page: page + 1}
+		entries, hasMore, err := QueryAuditPage(ctx, filter, 3)
+		if err != nil {
+			t.Fatalf("QueryAuditPage page %d: %v", filter.Page, err)
+		}
+		if len(entries) != wantLen {
+			t.Fatalf("page %d has %d entries, want %d", filter.Page, len(entries), wantLen)
+		}
+		if wantMore := filter.Page < 3; hasMore != wantMore {
+			t.Errorf("page %d hasMore = %v, want %v", filter.Page, hasMore, wantMore)
+		}
+		for _, entry := range entries {
+			if seen[entry.TargetID] {
+				t.Errorf("entry %s is on more than one page", entry.TargetID)
+			}
+			seen[entry.TargetID] = true
+			if !last.IsZero() && entry.Timestamp.After(last) {
+				t.Errorf("entry %s at %v is after the one before it at %v", entry.TargetID, entry.Timestamp, last)
+			}
+			last = entry.Timestamp
+		}
+	}
+	if len(seen) != total {
+		t.Errorf("pages held %d entries, want %d", len(seen), total)
+	}
+}
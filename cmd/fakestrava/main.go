@@ -0,0 +1,381 @@
+// Command fakestrava is a minimal stand-in for the Strava API used for local development.
+// Point the site at it with STRAVA_BASE_URL=http://localhost:8090.
+//
+// It implements just enough of Strava for the club site: OAuth (auto-approving a configurable
+// athlete), the athlete and routes endpoints, deauthorization and push subscriptions. The /fake/
+// endpoints trigger webhook events the way Strava would:
+//
+//	curl -X POST 'localhost:8090/fake/events?athlete=1001&type=deauthorize'
+//	curl -X POST 'localhost:8090/fake/events?athlete=1001&type=update&firstname=New'
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type athlete struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Profile   string `json:"profile"`
+}
+
+type route struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	Distance      float64  `json:"distance"`
+	ElevationGain float64  `json:"elevation_gain"`
+	Type          int      `json:"type"`
+	SubType       int      `json:"sub_type"`
+	Map           routeMap `json:"map"`
+}
+
+type routeMap struct {
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+type subscription struct {
+	ID          int64  `json:"id"`
+	CallbackURL string `json:"callback_url"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// fakeStrava holds all state in memory; restarting the server resets it
+type fakeStrava struct {
+	mu            sync.Mutex
+	athletes      map[int64]*athlete
+	routes        map[int64][]route // by athlete ID
+	accessTokens  map[string]int64  // token -> athlete ID
+	refreshTokens map[string]int64
+	revoked       map[int64]bool
+	subscription  *subscription
+	nextToken     int
+}
+
+func newFakeStrava() *fakeStrava {
+	f := &fakeStrava{
+		athletes:      map[int64]*athlete{},
+		routes:        map[int64][]route{},
+		accessTokens:  map[string]int64{},
+		refreshTokens: map[string]int64{},
+		revoked:       map[int64]bool{},
+	}
+	f.athletes[1001] = &athlete{ID: 1001, FirstName: "Alice", LastName: "Rider", Profile: "https://example.com/alice.jpg"}
+	f.athletes[1002] = &athlete{ID: 1002, FirstName: "Bob", LastName: "Climber", Profile: "https://example.com/bob.jpg"}
+	f.routes[1001] = []route{
+		{ID: 5001, Name: "Borrowash to Bakewell", Distance: 72400, ElevationGain: 1150, Type: 1, SubType: 1, Map: routeMap{bakewellPolyline}},
+		{ID: 5002, Name: "Thursday Chain Gang", Distance: 41800, ElevationGain: 320, Type: 1, SubType: 1},
+	}
+	f.routes[1002] = []route{
+		{ID: 6001, Name: "Winnats Pass Loop", Distance: 98100, ElevationGain: 1890, Type: 1, SubType: 1, Map: routeMap{winnatsPolyline}},
+		// The same roads as Alice's Borrowash to Bakewell, to try out duplicate route detection
+		{ID: 6002, Name: "Bakewell Pudding Run", Distance: 72100, ElevationGain: 1120, Type: 1, SubType: 1, Map: routeMap{bakewellCopyPolyline}},
+	}
+	return f
+}
+
+// Summary polylines for the sample routes, in Google's encoded polyline format
+const (
+	bakewellPolyline     = "_gzaI~olGc`@jMc`@lMc`@nMc`@rMc`@xMc`@`Nc`@hNc`@rNc`@|Nc`@jOc`@vOc`@fPc`@vPc`@hQc`@xQc`@nRc`@bSc`@vSc`@lTc`@dUc`@|Uc`@tVc`@lWc`@hXc`@`Yc`@|Yc`@vZc`@p[c`@l\\c`@h]c`@d^c`@~^c`@z_@c`@t`@c`@pa@c`@jb@c`@dc@c`@~c@c`@vd@c`@pe@c`@ff@c`@~f@c`@tg@c`@jh@c`@|h@c`@ri@c`@dj@c`@tj@c`@dk@c`@tk@c`@bl@c`@nl@c`@xl@c`@dm@c`@lm@c`@rm@c`@xm@c`@|m@c`@`n@c`@`n@"
+	bakewellCopyPolyline = "{hzaI~olGut@hVwt@pVut@zVwt@lWut@fXwt@bYut@fZwt@n[ut@|\\wt@n^ut@b`@wt@~a@ut@zc@ut@|e@wt@~g@ut@bj@wt@jl@ut@pn@wt@xp@ut@`s@wt@hu@ut@pw@wt@ry@ut@x{@ut@v}@wt@t_Aut@naAwt@dcAut@vdAwt@bfAut@lgAwt@nhAut@liAwt@djAut@vjAwt@dkAut@hkA"
+	winnatsPolyline      = "wpqdI~p~I`CgU`CaU`CqT`C{S`C}R`C{Q`CsP`CgO`CyM`CgL`CwJ`CeI`CwG`CkF`CcE`CaD`CcC`CmB`C}A`CwA"
+)
+
+// issueTokens creates a new access/refresh token pair for the athlete
+func (f *fakeStrava) issueTokens(athleteID int64) map[string]interface{} {
+	f.nextToken++
+	access := fmt.Sprintf("access-%d-%d", athleteID, f.nextToken)
+	refresh := fmt.Sprintf("refresh-%d-%d", athleteID, f.nextToken)
+	f.accessTokens[access] = athleteID
+	f.refreshTokens[refresh] = athleteID
+	expiresIn := 6 * 60 * 60
+	return map[string]interface{}{
+		"token_type":    "Bearer",
+		"access_token":  access,
+		"refresh_token": refresh,
+		"expires_at":    time.Now().Add(time.Duration(expiresIn) * time.Second).Unix(),
+		"expires_in":    expiresIn,
+		"athlete":       f.athletes[athleteID],
+	}
+}
+
+// athleteFromRequest resolves the bearer token on an API request
+func (f *fakeStrava) athleteFromRequest(r *http.Request) (int64, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.accessTokens[token]
+	return id, ok && !f.revoked[id]
+}
+
+// handleAuthorize auto-approves the request as ?athlete= (default 1001) and redirects back with a code
+func (f *fakeStrava) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	athleteID := r.URL.Query().Get("athlete")
+	if athleteID == "" {
+		athleteID = "1001"
+	}
+	redirect, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := redirect.Query()
+	q.Set("code", "code-"+athleteID)
+	q.Set("state", r.URL.Query().Get("state"))
+	q.Set("scope", "read,read_all")
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeStrava) handleToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var athleteID int64
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.FormValue("code"), "code-"), 10, 64)
+		if err != nil || f.athletes[id] == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+			return
+		}
+		athleteID = id
+		delete(f.revoked, id) // Logging in again re-authorizes the app
+	case "refresh_token":
+		id, ok := f.refreshTokens[r.FormValue("refresh_token")]
+		if !ok || f.revoked[id] {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"message": "Bad Request",
+				"errors":  []map[string]string{{"resource": "RefreshToken", "field": "refresh_token", "code": "invalid"}},
+			})
+			return
+		}
+		athleteID = id
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "unsupported grant_type"})
+		return
+	}
+	writeJSON(w, http.StatusOK, f.issueTokens(athleteID))
+}
+
+func (f *fakeStrava) handleDeauthorize(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	id, ok := f.accessTokens[r.FormValue("access_token")]
+	if ok {
+		f.revoked[id] = true
+	}
+	f.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": r.FormValue("access_token")})
+}
+
+func (f *fakeStrava) handleAthlete(w http.ResponseWriter, r *http.Request) {
+	id, ok := f.athleteFromRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writeJSON(w, http.StatusOK, f.athletes[id])
+}
+
+// handleAthleteRoutes serves /api/v3/athletes/{id}/routes
+func (f *fakeStrava) handleAthleteRoutes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.athleteFromRequest(r); !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := f.routes[id]
+	if routes == nil {
+		routes = []route{}
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// handleRoute serves /api/v3/routes/{id}
+func (f *fakeStrava) handleRoute(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.athleteFromRequest(r); !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, routes := range f.routes {
+		for _, rt := range routes {
+			if rt.ID == id {
+				writeJSON(w, http.StatusOK, rt)
+				return
+			}
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+}
+
+// handleSubscriptions implements create and list; like Strava it validates the callback before creating
+func (f *fakeStrava) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		subs := []subscription{}
+		if f.subscription != nil {
+			subs = append(subs, *f.subscription)
+		}
+		writeJSON(w, http.StatusOK, subs)
+	case http.MethodPost:
+		f.mu.Lock()
+		exists := f.subscription != nil
+		f.mu.Unlock()
+		if exists {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Bad Request", "errors": "already exists"})
+			return
+		}
+
+		callbackURL := r.FormValue("callback_url")
+		challenge := strconv.FormatInt(time.Now().UnixNano(), 36)
+		validateURL := fmt.Sprintf("%s?hub.mode=subscribe&hub.challenge=%s&hub.verify_token=%s",
+			callbackURL, challenge, url.QueryEscape(r.FormValue("verify_token")))
+		resp, err := http.Get(validateURL)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "callback url not verifiable: " + err.Error()})
+			return
+		}
+		defer resp.Body.Close()
+		var body map[string]string
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil || body["hub.challenge"] != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "callback url not verifiable"})
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		f.mu.Lock()
+		f.subscription = &subscription{ID: 1, CallbackURL: callbackURL, CreatedAt: now, UpdatedAt: now}
+		sub := *f.subscription
+		f.mu.Unlock()
+		writeJSON(w, http.StatusCreated, sub)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeStrava) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subscription == nil || r.PathValue("id") != strconv.FormatInt(f.subscription.ID, 10) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+		return
+	}
+	f.subscription = nil
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFakeEvent pushes an athlete event to the subscribed callback, as Strava would.
+// type=deauthorize revokes the athlete's tokens first; type=update applies ?firstname/lastname/profile.
+func (f *fakeStrava) handleFakeEvent(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := strconv.ParseInt(r.FormValue("athlete"), 10, 64)
+	if err != nil {
+		http.Error(w, "athlete is required", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	a := f.athletes[athleteID]
+	sub := f.subscription
+	updates := map[string]string{}
+	switch r.FormValue("type") {
+	case "deauthorize":
+		f.revoked[athleteID] = true
+		updates["authorized"] = "false"
+	case "update":
+		if a != nil {
+			for key, field := range map[string]*string{"firstname": &a.FirstName, "lastname": &a.LastName, "profile": &a.Profile} {
+				if v := r.FormValue(key); v != "" {
+					*field = v
+					updates[key] = v
+				}
+			}
+		}
+	default:
+		f.mu.Unlock()
+		http.Error(w, "type must be deauthorize or update", http.StatusBadRequest)
+		return
+	}
+	f.mu.Unlock()
+
+	if sub == nil {
+		http.Error(w, "no subscription; state updated but no event sent", http.StatusConflict)
+		return
+	}
+
+	event, _ := json.Marshal(map[string]interface{}{
+		"object_type":     "athlete",
+		"object_id":       athleteID,
+		"aspect_type":     "update",
+		"owner_id":        athleteID,
+		"subscription_id": sub.ID,
+		"event_time":      time.Now().Unix(),
+		"updates":         updates,
+	})
+	resp, err := http.Post(sub.CallbackURL, "application/json", bytes.NewReader(event))
+	if err != nil {
+		http.Error(w, "failed to deliver event: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	fmt.Fprintf(w, "delivered %s event for athlete %d (callback status %d)\n", r.FormValue("type"), athleteID, resp.StatusCode)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func main() {
+	f := newFakeStrava()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth/authorize", f.handleAuthorize)
+	mux.HandleFunc("POST /oauth/token", f.handleToken)
+	mux.HandleFunc("POST /oauth/deauthorize", f.handleDeauthorize)
+	mux.HandleFunc("GET /api/v3/athlete", f.handleAthlete)
+	mux.HandleFunc("GET /api/v3/athletes/{id}/routes", f.handleAthleteRoutes)
+	mux.HandleFunc("GET /api/v3/routes/{id}", f.handleRoute)
+	mux.HandleFunc("/api/v3/push_subscriptions", f.handleSubscriptions)
+	mux.HandleFunc("DELETE /api/v3/push_subscriptions/{id}", f.handleDeleteSubscription)
+	mux.HandleFunc("POST /fake/events", f.handleFakeEvent)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8090"
+	}
+	log.Printf("Fake Strava listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, mux))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// runCommand executes an admin command given on the command line instead of starting the web server,
+// e.g. `go run . strava-subscription list`.
+func runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "strava-subscription":
+		return runStravaSubscriptionCommand(ctx, args[1:])
+	case "migrate":
+		return runMigrateCommand(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: strava-subscription, migrate)", args[0])
+	}
+}
+
+// runStravaSubscriptionCommand manages the Strava webhook push subscription
+func runStravaSubscriptionCommand(ctx context.Context, args []string) error {
+	const usage = "usage: strava-subscription create|list|delete <id>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		sub, err := createStravaSubscription(ctx, oauthCallbackURL+"/webhooks/strava")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created Strava subscription %d\n", sub.ID)
+		fmt.Printf("Set STRAVA_WEBHOOK_SUBSCRIPTION_ID=%d and restart the site to start accepting its events.\n", sub.ID)
+	case "list":
+		subs, err := listStravaSubscriptions(ctx)
+		if err != nil {
+			return err
+		}
+		if len(subs) == 0 {
+			fmt.Println("No Strava subscriptions")
+		}
+		for _, sub := range subs {
+			fmt.Printf("%d\t%s\tcreated %s\n", sub.ID, sub.CallbackURL, sub.CreatedAt)
+		}
+	case "delete":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid subscription ID %q: %w", args[1], err)
+		}
+		if err := deleteStravaSubscription(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted Strava subscription %d\n", id)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
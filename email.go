@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Mailer delivers email. Messages are queued in the outbox and handed to the mailer by the
+// outbox worker, so a slow or failing mail server never holds up a request.
+type Mailer interface {
+	// Name identifies the mailer in logs
+	Name() string
+	// Send delivers one message
+	Send(ctx context.Context, msg *EmailMessage) error
+}
+
+// EmailMessage is a rendered email with HTML and plain text alternatives
+type EmailMessage struct {
+	To       string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// mailer is nil when email is disabled, in which case notifications are dropped
+var mailer Mailer
+
+// mailFrom is the From address on every email, e.g. "South Peaks CC <noreply@southpeakscc.co.uk>"
+var mailFrom = os.Getenv("MAIL_FROM")
+
+// newMailerFromEnv configures the mailer selected by MAIL_PROVIDER
+func newMailerFromEnv() (Mailer, error) {
+	provider := os.Getenv("MAIL_PROVIDER")
+	if provider == "" {
+		return nil, nil
+	}
+	if _, err := mail.ParseAddress(mailFrom); err != nil {
+		return nil, fmt.Errorf("MAIL_PROVIDER=%s requires a valid MAIL_FROM: %w", provider, err)
+	}
+
+	switch provider {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("MAIL_PROVIDER=smtp requires SMTP_HOST")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return newSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "file":
+		return newFileMailer(os.Getenv("MAIL_DIR")), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q (expected smtp or file)", provider)
+	}
+}
+
+// EmailData is passed to the email templates
+type EmailData struct {
+	SiteURL     string
+	FirstName   string
+	CurrentYear int
+
+	Season    int       // For membership emails
+	ExpiresAt time.Time // For membership emails
+	RouteName string    // For route emails
+	RouteURL  string    // For route emails
+	Note      string    // For route emails, the curator's reason for rejecting or asking for changes
+	VerifyURL string    // For the email verification email
+	Message   string    // For announcements from admins
+}
+
+// Paragraphs splits an announcement into paragraphs at blank lines
+func (d EmailData) Paragraphs() []string {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(d.Message, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// Email templates live in templates/email, separate from the pages. Each email has a .html and a
+// .txt version with the same name; the text version is parsed with text/template so it isn't
+// HTML-escaped.
+var (
+	emailHTMLTemplates *htmltemplate.Template
+	emailTextTemplates *texttemplate.Template
+)
+
+// loadEmailTemplates parses the email templates
+func loadEmailTemplates() error {
+	var err error
+	emailHTMLTemplates, err = htmltemplate.ParseGlob(filepath.Join("templates", "email", "*.html"))
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML email templates: %w", err)
+	}
+	emailTextTemplates, err = texttemplate.ParseGlob(filepath.Join("templates", "email", "*.txt"))
+	if err != nil {
+		return fmt.Errorf("failed to parse text email templates: %w", err)
+	}
+	return nil
+}
+
+// renderEmail renders the named email (e.g. "welcome") to a message for the recipient
+func renderEmail(name, to, subject string, data EmailData) (*EmailMessage, error) {
+	var html, text bytes.Buffer
+	if err := emailHTMLTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", name, err)
+	}
+	if err := emailTextTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", name, err)
+	}
+	return &EmailMessage{To: to, Subject: subject, HTMLBody: html.String(), TextBody: text.String()}, nil
+}
+
+// buildMIMEMessage encodes a message as multipart/alternative MIME, ready for SMTP or an .eml file
+func buildMIMEMessage(from string, msg *EmailMessage, now time.Time) ([]byte, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	toAddr, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	domain := fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMessage-ID: <%s@%s>\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n",
+		fromAddr.String(), toAddr.String(), mime.QEncoding.Encode("utf-8", msg.Subject),
+		now.Format(time.RFC1123Z), hex.EncodeToString(id), domain, body.Boundary())
+
+	// The last alternative is the preferred one, so the plain text goes first
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.TextBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return append([]byte(header), buf.Bytes()...), nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour // How long a verification link works
+	emailResendInterval  = time.Minute    // Minimum time between verification emails to a member
+	maxEmailLength       = 254
+)
+
+// errInvalidEmail is returned for an address a member typed that can't be used
+var errInvalidEmail = errors.New("enter a valid email address, e.g. name@example.com")
+
+// Errors for a verification link that can't be used
+var (
+	errInvalidVerificationLink = errors.New("invalid verification link")
+	errExpiredVerificationLink = errors.New("expired verification link")
+)
+
+// normalizeEmail validates an address typed by a member. Display names ("Ann <ann@example.com>")
+// aren't accepted, so the address is stored exactly as entered, minus surrounding spaces.
+func normalizeEmail(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" || len(input) > maxEmailLength {
+		return "", errInvalidEmail
+	}
+	addr, err := mail.ParseAddress(input)
+	if err != nil || addr.Address != input || !strings.Contains(input[strings.LastIndex(input, "@"):], ".") {
+		return "", errInvalidEmail
+	}
+	return input, nil
+}
+
+// emailVerificationSignature signs a verification link, binding it to the member, the address
+// and the expiry so none of them can be changed without invalidating it
+func emailVerificationSignature(stravaID int64, email string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(sessionSecretKey))
+	fmt.Fprintf(mac, "verify-email\n%d\n%s\n%d", stravaID, email, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkEmailVerificationLink reads the member and address from a verification link's query,
+// checking its signature and then its expiry
+func checkEmailVerificationLink(query url.Values, now time.Time) (int64, string, error) {
+	stravaID, idErr := strconv.ParseInt(query.Get("user"), 10, 64)
+	expires, expErr := strconv.ParseInt(query.Get("expires"), 10, 64)
+	email := query.Get("email")
+	expected := emailVerificationSignature(stravaID, email, expires)
+	if idErr != nil || expErr != nil || !hmac.Equal([]byte(query.Get("sig")), []byte(expected)) {
+		return 0, "", errInvalidVerificationLink
+	}
+	if now.Unix() > expires {
+		return 0, "", errExpiredVerificationLink
+	}
+	return stravaID, email, nil
+}
+
+// emailVerificationURL is the link emailed to a member to confirm a pending address
+func emailVerificationURL(stravaID int64, email string, now time.Time) string {
+	expires := now.Add(emailVerificationTTL).Unix()
+	query := url.Values{
+		"user":    {strconv.FormatInt(stravaID, 10)},
+		"email":   {email},
+		"expires": {strconv.FormatInt(expires, 10)},
+		"sig":     {emailVerificationSignature(stravaID, email, expires)},
+	}
+	return oauthCallbackURL + "/members/email/verify?" + query.Encode()
+}
+
+// SetPendingEmail records an address the member has asked to use but not yet confirmed. Their
+// current verified address, if any, keeps receiving email until they do.
+func SetPendingEmail(ctx context.Context, stravaID int64, email string, sentAt time.Time) error {
+	update := bson.M{"$set": bson.M{"pendingEmail": email, "emailVerificationSentAt": sentAt}}
+	result, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, bson.M{"stravaID": stravaID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to set pending email for user %d: %w", stravaID, err)
+	}
+	if result.MatchedCount == 0 {
+		return notFoundError("user", stravaID)
+	}
+	return nil
+}
+
+// ConfirmPendingEmail makes a pending address the member's verified address. It reports false if
+// the address is no longer pending, e.g. the link was already used or the member changed it again.
+func ConfirmPendingEmail(ctx context.Context, stravaID int64, email string, now time.Time) (bool, error) {
+	filter := bson.M{"stravaID": stravaID, "pendingEmail": email}
+	update := bson.M{"$set": bson.M{"email": email, "emailVerifiedAt": now, "pendingEmail": ""}}
+	result, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to confirm email for user %d: %w", stravaID, err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// sendVerificationEmail emails a signed link to the member's pending address
+func sendVerificationEmail(ctx context.Context, u *User, email string, now time.Time) error {
+	data := EmailData{VerifyURL: emailVerificationURL(u.StravaID, email, now), ExpiresAt: now.Add(emailVerificationTTL)}
+	return queueEmail(ctx, u, email, notifyVerifyEmail, "", "Confirm your email address for South Peaks CC", data)
+}
+
+// emailSettingsHandler starts verification of a new address for the logged-in member and
+// re-renders their email settings
+func emailSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Error(w, "Unauthorized: Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mailer == nil {
+		http.Error(w, "Email is not available", http.StatusNotFound)
+		return
+	}
+
+	data := TemplateData{User: user, IsAdmin: user.IsAdmin, EmailEnabled: true}
+	email, err := normalizeEmail(r.FormValue("email"))
+	now := time.Now()
+	switch {
+	case err != nil:
+		data.Notice = "Please " + err.Error() + "."
+	case strings.EqualFold(email, user.Email):
+		data.Notice = "That's already your confirmed address."
+	case email == user.PendingEmail && now.Sub(user.EmailVerificationSentAt) < emailResendInterval:
+		data.Notice = "We've just sent you a link. Please allow a minute for it to arrive before asking for another."
+	default:
+		ctx := r.Context()
+		if err := SetPendingEmail(ctx, user.StravaID, email, now); err != nil {
+			log.Printf("Error setting pending email for user %d: %v", user.StravaID, err)
+			http.Error(w, "Failed to save email address", http.StatusInternalServerError)
+			return
+		}
+		if err := sendVerificationEmail(ctx, user, email, now); err != nil {
+			log.Printf("Error queueing verification email for user %d: %v", user.StravaID, err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
+		user.PendingEmail = email
+		user.EmailVerificationSentAt = now
+		data.Notice = fmt.Sprintf("We've sent a link to %s. Click it within %d hours to confirm your address.", email, int(emailVerificationTTL.Hours()))
+		log.Printf("User %d asked to verify a new email address", user.StravaID)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "email_settings_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing email_settings_fragment template: %v", err)
+		http.Error(w, "Failed to render email settings", http.StatusInternalServerError)
+	}
+}
+
+// emailVerifyHandler confirms an address from the signed link in a verification email. It doesn't
+// need the member to be logged in, as the link is often opened on a different device.
+func emailVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	stravaID, email, linkErr := checkEmailVerificationLink(r.URL.Query(), now)
+	notice := ""
+	switch {
+	case errors.Is(linkErr, errInvalidVerificationLink):
+		w.WriteHeader(http.StatusBadRequest)
+		notice = "This confirmation link isn't valid. Please check you copied the whole link from the email."
+	case errors.Is(linkErr, errExpiredVerificationLink):
+		w.WriteHeader(http.StatusGone)
+		notice = "This confirmation link has expired. Enter your address again on the members page and we'll send a new one."
+	default:
+		ctx := r.Context()
+		confirmed, err := ConfirmPendingEmail(ctx, stravaID, email, now)
+		if err != nil {
+			log.Printf("Error confirming email for user %d: %v", stravaID, err)
+			http.Error(w, "Failed to confirm email address", http.StatusInternalServerError)
+			return
+		}
+		if !confirmed {
+			w.WriteHeader(http.StatusGone)
+			notice = "This confirmation link has already been used, or you've since asked to use a different address."
+			break
+		}
+		log.Printf("User %d confirmed their email address", stravaID)
+		notice = fmt.Sprintf("Thanks! Club emails will now go to %s.", email)
+
+		// Members are welcomed once they have an address to welcome them at
+		if member, err := GetUserByID(ctx, stravaID); err != nil {
+			log.Printf("Error loading user %d after confirming email: %v", stravaID, err)
+		} else if err := sendWelcomeEmail(ctx, member); err != nil {
+			log.Printf("Error queueing welcome email for user %d: %v", stravaID, err)
+		}
+	}
+
+	user, isLoggedIn := getUserFromSession(r)
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  isLoggedIn,
+		User:        user,
+		Notice:      notice,
+		CSSVersion:  cssVersion,
+	}
+
+	err := tmpl.ExecuteTemplate(w, "email_verified.html", data)
+	if err != nil {
+		log.Printf("Error executing email_verified template: %v", err)
+	}
+}
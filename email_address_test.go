@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"ann@example.com", "ann@example.com", false},
+		{"  ann@example.com \n", "ann@example.com", false},
+		{"Ann.Smith+club@mail.example.co.uk", "Ann.Smith+club@mail.example.co.uk", false},
+		{"", "", true},
+		{"   ", "", true},
+		{"ann", "", true},
+		{"ann@localhost", "", true},
+		{"ann@example.com, bob@example.com", "", true},
+		{"Ann <ann@example.com>", "", true},
+		{"<ann@example.com>", "", true},
+		{"ann@@example.com", "", true},
+		{"ann@" + strings.Repeat("a", maxEmailLength) + ".com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEmail(tt.input)
+		if tt.wantErr {
+			if !errors.Is(err, errInvalidEmail) {
+				t.Errorf("normalizeEmail(%q) = %q, %v, want errInvalidEmail", tt.input, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeEmail(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckEmailVerificationLink(t *testing.T) {
+	const stravaID = 12345
+	const email = "ann@example.com"
+	sentAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	link, err := url.Parse(emailVerificationURL(stravaID, email, sentAt))
+	if err != nil {
+		t.Fatalf("parsing verification URL: %v", err)
+	}
+	valid := link.Query()
+	expires := sentAt.Add(emailVerificationTTL)
+
+	// with returns the valid link's query with one parameter changed
+	with := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range valid {
+			q[k] = v
+		}
+		q.Set(key, value)
+		return q
+	}
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		now     time.Time
+		wantErr error
+	}{
+		{"valid", valid, sentAt.Add(time.Hour), nil},
+		{"valid until it expires", valid, expires, nil},
+		{"expired", valid, expires.Add(time.Second), errExpiredVerificationLink},
+		{"other member", with("user", "54321"), sentAt, errInvalidVerificationLink},
+		{"other address", with("email", "mallory@example.com"), sentAt, errInvalidVerificationLink},
+		{"extended expiry", with("expires", strconv.FormatInt(expires.Add(24*time.Hour).Unix(), 10)), expires.Add(time.Hour), errInvalidVerificationLink},
+		{"tampered signature", with("sig", strings.Repeat("0", len(valid.Get("sig")))), sentAt, errInvalidVerificationLink},
+		{"missing signature", with("sig", ""), sentAt, errInvalidVerificationLink},
+		{"malformed member", with("user", "ann"), sentAt, errInvalidVerificationLink},
+		{"malformed expiry", with("expires", "soon"), sentAt, errInvalidVerificationLink},
+		{"empty", url.Values{}, sentAt, errInvalidVerificationLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotID, gotEmail, err := checkEmailVerificationLink(tt.query, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkEmailVerificationLink error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (gotID != stravaID || gotEmail != email) {
+				t.Errorf("checkEmailVerificationLink = %d, %q, want %d, %q", gotID, gotEmail, stravaID, email)
+			}
+		})
+	}
+}
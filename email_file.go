@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileMailer is for local development. It writes each message to an .eml file in dir, which most
+// mail clients can open, or logs the plain text version when no directory is set.
+type fileMailer struct {
+	dir string
+}
+
+func newFileMailer(dir string) *fileMailer {
+	return &fileMailer{dir: dir}
+}
+
+func (m *fileMailer) Name() string { return "file" }
+
+func (m *fileMailer) Send(ctx context.Context, msg *EmailMessage) error {
+	if m.dir == "" {
+		log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.TextBody)
+		return nil
+	}
+
+	now := time.Now()
+	data, err := buildMIMEMessage(mailFrom, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	path := filepath.Join(m.dir, fmt.Sprintf("%s-%d.eml", now.Format("20060102-150405"), now.UnixNano()%1e9))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	log.Printf("Email to %s written to %s", msg.To, path)
+	return nil
+}
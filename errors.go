@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors returned by the store functions, wrapped with what wasn't found or was invalid. Check for
+// them with errors.Is rather than by message.
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrInvalidID = errors.New("invalid ID")
+	ErrDuplicate = errors.New("already exists")
+)
+
+// notFoundError reports that the kind of document with the given ID doesn't exist, e.g. "user 123 not found"
+func notFoundError(kind string, id any) error {
+	return fmt.Errorf("%s %v %w", kind, id, ErrNotFound)
+}
+
+// duplicateError reports that a document would break a unique index
+func duplicateError(kind string, id any, err error) error {
+	return fmt.Errorf("%s %v %w: %w", kind, id, ErrDuplicate, err)
+}
+
+// parseObjectID parses the hex ID of a kind of document, as found in forms and URLs
+func parseObjectID(kind, id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%w for %s %q: %w", ErrInvalidID, kind, id, err)
+	}
+	return objID, nil
+}
+
+// errorStatus maps an error from the store to the HTTP status to respond with
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrDuplicate):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStoreError responds to a request that failed because of an error from the store, with a
+// message about the kind of thing that was being loaded or saved, e.g. "Route not found". HTMX
+// requests get error_fragment.html, appended to the page, as htmx doesn't swap in error responses
+// of its own accord; see static/js/htmx-errors.js. The caller logs the error.
+func writeStoreError(w http.ResponseWriter, r *http.Request, kind string, err error) {
+	status := errorStatus(err)
+	var message string
+	switch status {
+	case http.StatusNotFound:
+		message = kind + " not found"
+	case http.StatusBadRequest:
+		message = "Invalid " + strings.ToLower(kind) + " ID"
+	case http.StatusConflict:
+		message = kind + " already exists"
+	default:
+		message = "Something went wrong, please try again"
+	}
+
+	if r.Header.Get("HX-Request") != "true" {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("HX-Retarget", "body")
+	w.Header().Set("HX-Reswap", "beforeend")
+	w.WriteHeader(status)
+	if err := tmpl.ExecuteTemplate(w, "error_fragment.html", TemplateData{Error: message}); err != nil {
+		log.Printf("Error executing error fragment template: %v", err)
+	}
+}
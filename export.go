@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MemberDataExport is everything the club holds about a single member, as handed over for
+// a data export or subject access request. Strava tokens are deliberately left out.
+type MemberDataExport struct {
+	GeneratedAt  time.Time          `json:"generatedAt"`
+	Member       ExportedMember     `json:"member"`
+	Routes       []ExportedRoute    `json:"routes"`
+	RideRSVPs    []ExportedRideRSVP `json:"rideRSVPs"`
+	AuditEntries []ExportedAuditLog `json:"auditEntries"`
+	Emails       []ExportedEmail    `json:"emails"`
+}
+
+// ExportedMember lists the User fields included in an export. Fields are copied explicitly so a
+// new secret added to User is never exported by accident.
+type ExportedMember struct {
+	StravaID             int64     `json:"stravaID"`
+	FirstName            string    `json:"firstName"`
+	LastName             string    `json:"lastName"`
+	ProfilePicURL        string    `json:"profilePicURL"`
+	IsPaidMember         bool      `json:"isPaidMember"`
+	IsAdmin              bool      `json:"isAdmin"`
+	Roles                []string  `json:"roles"`
+	LastLogin            time.Time `json:"lastLogin"`
+	StravaConnected      bool      `json:"stravaConnected"`
+	StravaDisconnectedAt time.Time `json:"stravaDisconnectedAt,omitempty"`
+	DateOfBirth          time.Time `json:"dateOfBirth,omitempty"`
+	Email                string    `json:"email,omitempty"`
+	EmailVerifiedAt      time.Time `json:"emailVerifiedAt,omitempty"`
+	PendingEmail         string    `json:"pendingEmail,omitempty"`
+	DeletedAt            time.Time `json:"deletedAt,omitempty"` // Set if the account is in the trash
+
+	Profile     ExportedProfile      `json:"profile"`
+	Privacy     ExportedPrivacy      `json:"privacy"`
+	Memberships []ExportedMembership `json:"memberships"`
+}
+
+// ExportedProfile is what the member told the club during onboarding
+type ExportedProfile struct {
+	Phone                        string    `json:"phone"`
+	EmergencyContactName         string    `json:"emergencyContactName"`
+	EmergencyContactRelationship string    `json:"emergencyContactRelationship"`
+	EmergencyContactPhone        string    `json:"emergencyContactPhone"`
+	MedicalNotes                 string    `json:"medicalNotes,omitempty"`      // Left out of admin exports, see buildMemberExport
+	MedicalAnsweredAt            time.Time `json:"medicalAnsweredAt,omitempty"` // Left out with the notes
+	PhotoConsent                 bool      `json:"photoConsent"`
+	PhotoAnsweredAt              time.Time `json:"photoAnsweredAt,omitempty"`
+	RulesVersion                 string    `json:"rulesVersion,omitempty"`
+	RulesAcceptedAt              time.Time `json:"rulesAcceptedAt,omitempty"`
+}
+
+// ExportedMembership is a paid season
+type ExportedMembership struct {
+	Season      int       `json:"season"`
+	Type        string    `json:"type"`
+	AmountPence int       `json:"amountPence"`
+	PaidAt      time.Time `json:"paidAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ExportedPrivacy is the member's directory privacy settings
+type ExportedPrivacy struct {
+	NameDisplay       string `json:"nameDisplay"`
+	HidePhoto         bool   `json:"hidePhoto"`
+	HideFromDirectory bool   `json:"hideFromDirectory"`
+}
+
+// ExportedRoute is a route the member submitted
+type ExportedRoute struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Classify    string    `json:"classify"`
+	Status      string    `json:"status,omitempty"`
+	SubmittedAt time.Time `json:"submittedAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	DeletedAt   time.Time `json:"deletedAt,omitempty"`
+}
+
+// ExportedRideRSVP is a ride the member signed up for
+type ExportedRideRSVP struct {
+	RideID   string    `json:"rideID"`
+	Title    string    `json:"title"`
+	StartsAt time.Time `json:"startsAt"`
+	RSVPAt   time.Time `json:"rsvpAt"`
+}
+
+// ExportedEmail is an email the club queued for or sent to the member
+type ExportedEmail struct {
+	Kind      string    `json:"kind"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	SentAt    time.Time `json:"sentAt,omitempty"`
+}
+
+// ExportedAuditLog is an audit entry where the member was the actor or the target
+type ExportedAuditLog struct {
+	Action    string    `json:"action"`
+	ActorID   int64     `json:"actorID"`
+	TargetID  string    `json:"targetID"`
+	Details   string    `json:"details"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// buildMemberExport gathers everything stored about the member with the given StravaID, including
+// an account and the routes and emails in the trash with it, which are still held until they're purged. Medical notes
+// are only included if includeMedical is set, as not everyone who can export a member's data
+// may see them.
+func buildMemberExport(ctx context.Context, stravaID int64, includeMedical bool) (*MemberDataExport, error) {
+	user, err := GetUserByIDIncludingDeleted(ctx, stravaID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &MemberDataExport{
+		GeneratedAt: time.Now(),
+		Member: ExportedMember{
+			StravaID:             user.StravaID,
+			FirstName:            user.FirstName,
+			LastName:             user.LastName,
+			ProfilePicURL:        user.ProfilePicURL,
+			IsPaidMember:         user.IsPaidMember,
+			IsAdmin:              user.IsAdmin,
+			Roles:                append([]string{}, user.Roles...),
+			LastLogin:            user.LastLogin,
+			StravaConnected:      !user.StravaDisconnected,
+			StravaDisconnectedAt: user.StravaDisconnectedAt,
+			DateOfBirth:          user.DateOfBirth,
+			Email:                user.Email,
+			EmailVerifiedAt:      user.EmailVerifiedAt,
+			PendingEmail:         user.PendingEmail,
+			DeletedAt:            user.DeletedAt,
+			Profile: ExportedProfile{
+				Phone:                        user.Profile.Phone,
+				EmergencyContactName:         user.Profile.EmergencyContact.Name,
+				EmergencyContactRelationship: user.Profile.EmergencyContact.Relationship,
+				EmergencyContactPhone:        user.Profile.EmergencyContact.Phone,
+				PhotoConsent:                 user.Profile.PhotoConsent,
+				PhotoAnsweredAt:              user.Profile.PhotoAnsweredAt,
+				RulesVersion:                 user.Profile.RulesVersion,
+				RulesAcceptedAt:              user.Profile.RulesAcceptedAt,
+			},
+			Privacy: ExportedPrivacy{
+				NameDisplay:       user.Privacy.NameDisplay,
+				HidePhoto:         user.Privacy.HidePhoto,
+				HideFromDirectory: user.Privacy.HideFromDirectory,
+			},
+		},
+		Routes:       []ExportedRoute{},
+		RideRSVPs:    []ExportedRideRSVP{},
+		AuditEntries: []ExportedAuditLog{},
+		Emails:       []ExportedEmail{},
+	}
+	if includeMedical {
+		export.Member.Profile.MedicalNotes = user.Profile.MedicalNotes
+		export.Member.Profile.MedicalAnsweredAt = user.Profile.MedicalAnsweredAt
+	}
+
+	export.Member.Memberships = []ExportedMembership{}
+	for _, m := range user.Memberships {
+		export.Member.Memberships = append(export.Member.Memberships, ExportedMembership{
+			Season:      m.Season,
+			Type:        m.Type,
+			AmountPence: m.AmountPence,
+			PaidAt:      m.PaidAt,
+			ExpiresAt:   m.ExpiresAt,
+		})
+	}
+
+	routes, err := findRoutesIncludingDeleted(ctx, bson.M{"submittedByUserID": stravaID}, bson.D{{Key: "submittedAt", Value: -1}})
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		export.Routes = append(export.Routes, ExportedRoute{
+			ID:          route.ID,
+			Name:        route.Name,
+			URL:         route.URL,
+			Classify:    route.Classify,
+			Status:      route.Status,
+			SubmittedAt: route.SubmittedAt,
+			UpdatedAt:   route.UpdatedAt,
+			DeletedAt:   route.DeletedAt,
+		})
+	}
+
+	rides, err := GetUserRSVPRides(ctx, stravaID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ride := range rides {
+		for _, rsvp := range ride.RSVPs {
+			if rsvp.UserID == stravaID {
+				export.RideRSVPs = append(export.RideRSVPs, ExportedRideRSVP{
+					RideID:   ride.ID,
+					Title:    ride.Title,
+					StartsAt: ride.StartsAt,
+					RSVPAt:   rsvp.At,
+				})
+			}
+		}
+	}
+
+	entries, err := GetAuditEntriesForUser(ctx, stravaID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		export.AuditEntries = append(export.AuditEntries, ExportedAuditLog{
+			Action:    entry.Action,
+			ActorID:   entry.ActorID,
+			TargetID:  entry.TargetID,
+			Details:   entry.Details,
+			Timestamp: entry.Timestamp,
+		})
+	}
+
+	emails, err := GetUserEmails(ctx, stravaID)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range emails {
+		export.Emails = append(export.Emails, ExportedEmail{
+			Kind:      msg.Kind,
+			To:        msg.To,
+			Subject:   msg.Subject,
+			Body:      msg.TextBody,
+			Status:    msg.Status,
+			CreatedAt: msg.CreatedAt,
+			SentAt:    msg.SentAt,
+		})
+	}
+
+	return export, nil
+}
+
+// writeMemberExportZip writes the export as a ZIP containing one JSON file per section plus the whole export
+func writeMemberExportZip(w http.ResponseWriter, export *MemberDataExport) error {
+	filename := fmt.Sprintf("southpeakscc-data-%d-%s.zip", export.Member.StravaID, export.GeneratedAt.Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"export.json", export},
+		{"member.json", export.Member},
+		{"routes.json", export.Routes},
+		{"rides.json", export.RideRSVPs},
+		{"audit.json", export.AuditEntries},
+		{"emails.json", export.Emails},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to export zip: %w", file.name, err)
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return fmt.Errorf("failed to write %s to export zip: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// memberExportHandler lets a logged-in member download everything the club holds about them
+func memberExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+
+	export, err := buildMemberExport(r.Context(), user.StravaID, true)
+	if err != nil {
+		log.Printf("Error building data export for user %d: %v", user.StravaID, err)
+		http.Error(w, "Failed to build your data export", http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeMemberExportZip(w, export); err != nil {
+		log.Printf("Error writing data export for user %d: %v", user.StravaID, err)
+	}
+}
+
+// adminMemberExportHandler lets an admin export a member's data to answer a subject access request.
+// Medical notes are only included for those who may see them, i.e. ride leaders.
+func adminMemberExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permExportMemberData) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(r.FormValue("userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	includeMedical := user.Can(permViewMedicalNotes)
+	export, err := buildMemberExport(ctx, targetUserID, includeMedical)
+	if err != nil {
+		log.Printf("Error building admin data export for user %d: %v", targetUserID, err)
+		writeStoreError(w, r, "User", err)
+		return
+	}
+
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionDataExported,
+		TargetID:  strconv.FormatInt(targetUserID, 10),
+		Details:   "Subject access request export",
+	}
+	if !includeMedical {
+		audit.Details += " (without medical notes)"
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for data export of user %d: %v", targetUserID, err)
+	}
+
+	if err := writeMemberExportZip(w, export); err != nil {
+		log.Printf("Error writing admin data export for user %d: %v", targetUserID, err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io" // Use io instead of deprecated ioutil
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,8 +35,9 @@ type StravaRouteAPI struct {
 	ElevationGain float64     `json:"elevation_gain"` // Meters
 	Type          interface{} `json:"type"`           // Can be string or number from Strava API
 	SubType       interface{} `json:"sub_type"`       // Can be string or number from Strava API
-	// You might add more fields from Strava API if needed for display
-	// e.g., Map struct for polyline, segments
+	Map           struct {
+		SummaryPolyline string `json:"summary_polyline"` // Used to spot duplicate routes
+	} `json:"map"`
 }
 
 // stravaLoginHandler redirects user to Strava for OAuth authorization
@@ -86,57 +89,70 @@ func stravaCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user exists in DB, create or update
-	user, err := GetUserByID(ctx, athlete.ID)
-	if err != nil && err.Error() == "user not found" {
-		// User does not exist, create new
-		user = &User{
-			StravaID:       athlete.ID,
-			FirstName:      athlete.FirstName,
-			LastName:       athlete.LastName,
-			ProfilePicURL:  athlete.Profile,
-			IsPaidMember:   false, // Default to not paid
-			IsAdmin:        false, // Default to not admin
-			LastLogin:      time.Now(),
-			AccessToken:    token.AccessToken,
-			RefreshToken:   token.RefreshToken,
-			AccessTokenExp: token.Expiry,
-		}
-		if err := CreateUser(ctx, user); err != nil {
-			log.Printf("Error creating user in DB: %v", err)
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
-			return
+	// A member who deleted their account and logs in again before it's purged gets it back, with
+	// the routes deleted with it. Once it's purged they start afresh.
+	if restored, err := RestoreUser(ctx, athlete.ID); err != nil {
+		log.Printf("Error restoring deleted account of returning user %d: %v", athlete.ID, err)
+		http.Error(w, "Failed to restore your account", http.StatusInternalServerError)
+		return
+	} else if restored != nil {
+		log.Printf("Restored deleted account of returning user %d from the trash", athlete.ID)
+		audit := &AuditEntry{
+			ActorID:   restored.StravaID,
+			ActorName: fmt.Sprintf("%s %s", restored.FirstName, restored.LastName),
+			Action:    auditActionTrashRestored,
+			TargetID:  strconv.FormatInt(restored.StravaID, 10),
+			Details:   "account: restored by logging in again",
 		}
-		log.Printf("New user registered: %s %s (Strava ID: %d)", user.FirstName, user.LastName, user.StravaID)
-	} else if err != nil {
-		log.Printf("Error getting user from DB: %v", err)
-		http.Error(w, "Failed to retrieve user data", http.StatusInternalServerError)
+		if err := RecordAudit(ctx, audit); err != nil {
+			log.Printf("Error recording audit entry for restore of user %d: %v", restored.StravaID, err)
+		}
+	}
+
+	// Create the user on their first login, otherwise update their tokens and last login time
+	user, isNew, err := UpsertUserOnLogin(ctx, athlete, token, time.Now())
+	if err != nil {
+		log.Printf("Error recording login in DB: %v", err)
+		http.Error(w, "Failed to update user data", http.StatusInternalServerError)
 		return
+	}
+	if isNew {
+		log.Printf("New user registered: %s %s (Strava ID: %d)", user.FirstName, user.LastName, user.StravaID)
 	} else {
-		// User exists, update tokens and last login time
-		user.AccessToken = token.AccessToken
-		user.RefreshToken = token.RefreshToken
-		user.AccessTokenExp = token.Expiry
-		user.LastLogin = time.Now()
-		if err := UpdateUser(ctx, user); err != nil {
-			log.Printf("Error updating user in DB: %v", err)
-			http.Error(w, "Failed to update user data", http.StatusInternalServerError)
-			return
+		now := time.Now()
+		if err := TouchLastLogin(ctx, user.StravaID, now); err != nil {
+			log.Printf("Error recording last login for user %d: %v", user.StravaID, err)
+		} else {
+			user.LastLogin = now
 		}
 		log.Printf("User logged in: %s %s (Strava ID: %d)", user.FirstName, user.LastName, user.StravaID)
 	}
 
+	// Users listed in ADMIN_STRAVA_IDS become admins on their first login
+	if isBootstrapAdmin(user.StravaID) && !user.HasRole(roleAdmin) {
+		if err := SetUserRoles(ctx, user.StravaID, append(user.Roles, roleAdmin)); err != nil {
+			log.Printf("Error granting bootstrap admin role to user %d: %v", user.StravaID, err)
+		} else {
+			log.Printf("Granted admin role to user %d from ADMIN_STRAVA_IDS", user.StravaID)
+		}
+	}
+
 	// Set user ID in session
 	session.Values["userID"] = athlete.ID
 	session.Save(r, w)
 
+	// New members, and members who haven't accepted the current club rules, go through onboarding
+	if !user.OnboardingComplete() {
+		http.Redirect(w, r, "/welcome", http.StatusFound)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusFound) // Redirect to home page
 }
 
 // getStravaAthleteDetails fetches the current athlete's details using their access token
 func getStravaAthleteDetails(ctx context.Context, accessToken string) (*StravaAthlete, error) {
 	client := stravaOAuthConf.Client(ctx, &oauth2.Token{AccessToken: accessToken})
-	resp, err := client.Get("https://www.strava.com/api/v3/athlete")
+	resp, err := client.Get(stravaBaseURL + "/api/v3/athlete")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get athlete details: %w", err)
 	}
@@ -184,8 +200,14 @@ func membersHandler(w http.ResponseWriter, r *http.Request) {
 		IsLoggedIn:  true,
 		User:        user,
 		IsAdmin:     user.IsAdmin,
-		Members:     members,
+		Members:     directoryMembers(members, user),
 		CSSVersion:  cssVersion, // Use Unix timestamp for cache busting
+
+		PaymentsEnabled: paymentProvider != nil,
+		TierOptions:     tierOptionsFor(user, seasonFor(time.Now())),
+		EmailEnabled:    mailer != nil,
+
+		TrashRetentionDays: trashRetentionDays(),
 	}
 
 	err = tmpl.ExecuteTemplate(w, "members.html", data) // Render members template
@@ -198,7 +220,7 @@ func membersHandler(w http.ResponseWriter, r *http.Request) {
 // adminTogglePaidHandler allows an admin to toggle paid status for a member
 func adminTogglePaidHandler(w http.ResponseWriter, r *http.Request) {
 	user, isLoggedIn := getUserFromSession(r)
-	if !isLoggedIn || !user.IsAdmin { // Must be logged in AND an admin
+	if !isLoggedIn || !user.Can(permManageMemberships) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -218,18 +240,50 @@ func adminTogglePaidHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	targetUser, err := GetUserByID(ctx, targetUserID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		log.Printf("Error getting user %d: %v", targetUserID, err)
+		writeStoreError(w, r, "User", err)
 		return
 	}
 
-	// Toggle paid status
-	targetUser.IsPaidMember = !targetUser.IsPaidMember
-	if err := UpdateUser(ctx, targetUser); err != nil {
+	// The form says which way to toggle, so a second admin clicking on a stale page doesn't undo
+	// the first admin's change. Older pages without it toggle from the status loaded here.
+	paid := !targetUser.IsPaidMember
+	if value := r.FormValue("paid"); value != "" {
+		paid = value == "true"
+	}
+
+	// Mark paid with a default tier membership for the current season, or end the running membership
+	var membership Membership
+	if paid {
+		tier := defaultTier()
+		if tier == nil {
+			http.Error(w, "No membership tiers configured", http.StatusInternalServerError)
+			return
+		}
+		membership = newSeasonMembership(seasonFor(time.Now()), tier.Key, tier.PricePence, user.StravaID)
+	}
+	changed, err := SetPaidStatus(ctx, targetUserID, paid, membership)
+	if err != nil {
 		log.Printf("Error toggling paid status for user %d: %v", targetUserID, err)
 		http.Error(w, "Failed to update paid status", http.StatusInternalServerError)
 		return
 	}
 
+	if changed {
+		audit := &AuditEntry{
+			ActorID:   user.StravaID,
+			ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			Action:    auditActionPaidToggled,
+			TargetID:  targetUserIDStr,
+			Details:   fmt.Sprintf("%s %s", targetUser.FirstName, targetUser.LastName),
+			Before:    map[string]string{"paid": strconv.FormatBool(!paid)},
+			After:     map[string]string{"paid": strconv.FormatBool(paid)},
+		}
+		if err := RecordAudit(ctx, audit); err != nil {
+			log.Printf("Error recording audit entry for paid toggle of user %d: %v", targetUserID, err)
+		}
+	}
+
 	// After submission, re-fetch all members to re-render the list dynamically via HTMX
 	members, err := GetAllUsers(ctx)
 	if err != nil {
@@ -239,8 +293,9 @@ func adminTogglePaidHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := TemplateData{ // Populate data for the fragment
+		User:    user,
 		IsAdmin: user.IsAdmin,
-		Members: members,
+		Members: directoryMembers(members, user),
 	}
 
 	// Render only the members_grid_fragment.html template for HTMX swap
@@ -252,8 +307,16 @@ func adminTogglePaidHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// deleteAccountHandler handles user account deletion
+// deleteAccountHandler handles user account deletion.
+// It revokes the club's Strava access, deletes or hands over the member's routes as they chose,
+// moves the user document to the trash and records an audit entry. The account and any deleted
+// routes are purged once the trash retention period has passed, see trash.go.
 func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	session, err := store.Get(r, "session-name")
 	if err != nil {
 		log.Printf("Error getting session for delete: %v", err)
@@ -261,36 +324,137 @@ func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userIDVal := session.Values["userID"]
-	if userIDVal == nil {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
 		http.Error(w, "Not logged in", http.StatusUnauthorized)
 		return
 	}
-	userID := userIDVal.(int64)
+	userID := user.StravaID
+
+	routesChoice := r.FormValue("routes")
+	if routesChoice != "delete" && routesChoice != "reassign" {
+		http.Error(w, "Please choose what should happen to your submitted routes", http.StatusBadRequest)
+		return
+	}
 
 	ctx := r.Context()
 
-	// 1. Delete user from DB
-	if err := DeleteUser(ctx, userID); err != nil {
+	// 1. Revoke the club's access on Strava. A failure here must not block deletion.
+	stravaStatus := "deauthorized"
+	if accessToken, err := GetFreshStravaToken(ctx, user); errors.Is(err, errStravaAuthRevoked) {
+		stravaStatus = "already revoked"
+	} else if err != nil {
+		log.Printf("Error getting fresh Strava token to deauthorize user %d: %v", userID, err)
+		stravaStatus = "deauthorization failed"
+	} else if err := deauthorizeStrava(ctx, accessToken); err != nil {
+		log.Printf("Error deauthorizing Strava for user %d: %v", userID, err)
+		stravaStatus = "deauthorization failed"
+	}
+
+	// 2. Delete or reassign the member's submitted routes
+	userIDStr := strconv.FormatInt(userID, 10)
+	deletedAt := trashTime()
+	var routeCount int64
+	routesOutcome := "reassigned to club"
+	if routesChoice == "delete" {
+		routeCount, err = SoftDeleteUserRoutes(ctx, user, deletedAt)
+		routesOutcome = "deleted"
+	} else {
+		routeCount, err = ReassignUserRoutesToClub(ctx, userID)
+		if err == nil {
+			err = ReassignUserRouteRevisionsToClub(ctx, userID)
+		}
+	}
+	if err != nil {
+		log.Printf("Error handling routes (%s) for deleted user %d: %v", routesChoice, userID, err)
+		http.Error(w, "Failed to process your submitted routes", http.StatusInternalServerError)
+		return
+	}
+
+	// 3. Move the user to the trash
+	if err := SoftDeleteUser(ctx, userID, deletedAt); err != nil {
 		log.Printf("Error deleting user %d from DB: %v", userID, err)
 		http.Error(w, "Failed to delete account from database", http.StatusInternalServerError)
 		return
 	}
+	if err := SoftDeleteUserEmails(ctx, userID, deletedAt); err != nil {
+		log.Printf("Error deleting queued emails for deleted user %d: %v", userID, err)
+	}
+	if err := DeleteUserRSVPs(ctx, userID); err != nil {
+		log.Printf("Error deleting ride RSVPs for deleted user %d: %v", userID, err)
+	}
+
+	// 4. Record the deletion in the audit log
+	audit := &AuditEntry{
+		ActorID:   userID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionAccountDeleted,
+		TargetID:  userIDStr,
+		Details:   fmt.Sprintf("Strava: %s; routes: %d %s", stravaStatus, routeCount, routesOutcome),
+		Before: map[string]string{
+			"name":  fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			"paid":  strconv.FormatBool(user.IsPaidMember),
+			"roles": strings.Join(user.Roles, ","),
+		},
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for deleted user %d: %v", userID, err)
+	}
 
-	// 2. Clear user session
+	// 5. Clear user session
 	session.Values["userID"] = nil
 	session.Options.MaxAge = -1
 	if err := session.Save(r, w); err != nil {
 		log.Printf("Error saving session after delete: %v", err)
 	}
 
-	log.Printf("Account for Strava ID %d deleted successfully.", userID)
+	log.Printf("Account for Strava ID %d deleted successfully (%s).", userID, audit.Details)
 
 	// Use HX-Redirect for a clean browser-side redirect
-	w.Header().Set("HX-Redirect", "/") // Tell HTMX to redirect the browser to the home page
+	w.Header().Set("HX-Redirect", "/account-deleted") // Tell HTMX to redirect the browser to the confirmation page
 	w.WriteHeader(http.StatusOK)
 }
 
+// accountDeletedHandler shows the confirmation page after an account has been deleted
+func accountDeletedHandler(w http.ResponseWriter, r *http.Request) {
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		CSSVersion:  cssVersion,
+
+		TrashRetentionDays: trashRetentionDays(),
+	}
+
+	err := tmpl.ExecuteTemplate(w, "account_deleted.html", data)
+	if err != nil {
+		log.Printf("Error executing account_deleted template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// deauthorizeStrava revokes the club's access to the athlete's Strava account
+func deauthorizeStrava(ctx context.Context, accessToken string) error {
+	form := url.Values{"access_token": {accessToken}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stravaBaseURL+"/oauth/deauthorize", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build deauthorize request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Strava deauthorize: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Strava answers 401 when the token is already revoked, which is the outcome we want anyway
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("strava deauthorize returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
 // routesHandler displays the routes page
 func routesHandler(w http.ResponseWriter, r *http.Request) {
 	user, isLoggedIn := getUserFromSession(r)
@@ -298,9 +462,16 @@ func routesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login/strava", http.StatusFound) // Must be logged in to view routes
 		return
 	}
+	if !user.Can(permManageRoutes) && !user.HasCapability(capViewRoutes) {
+		http.Redirect(w, r, "/members", http.StatusFound) // Members page explains how to join
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
 
 	ctx := r.Context()
-	routes, err := GetAllRoutes(ctx) // All club routes from DB
+	routes, err := GetApprovedRoutes(ctx) // The club's route library
 	if err != nil {
 		log.Printf("Error fetching all club routes for routes page: %v", err)
 		http.Error(w, "Failed to load club routes list", http.StatusInternalServerError)
@@ -309,7 +480,7 @@ func routesHandler(w http.ResponseWriter, r *http.Request) {
 
 	userSubmittedRoutes := []Route{}
 	if isLoggedIn {
-		userSubmittedRoutes, err = GetUserRoutes(ctx, strconv.FormatInt(user.StravaID, 10))
+		userSubmittedRoutes, err = GetUserRoutes(ctx, user.StravaID)
 		if err != nil {
 			log.Printf("Error fetching user's previously submitted routes: %v", err)
 		}
@@ -317,7 +488,7 @@ func routesHandler(w http.ResponseWriter, r *http.Request) {
 
 	stravaUserRoutesForDropdown := []StravaRouteAPI{}
 	var stravaUserRoutesOptions string
-	if isLoggedIn && user.IsPaidMember {
+	if isLoggedIn && user.HasCapability(capSubmitRoutes) {
 		accessToken, err := GetFreshStravaToken(ctx, user)
 		if err != nil {
 			log.Printf("Error getting fresh Strava token for routes page initial load: %v", err)
@@ -355,7 +526,7 @@ func routesHandler(w http.ResponseWriter, r *http.Request) {
 func fetchStravaUserRoutes(ctx context.Context, accessToken string, athleteID int64) ([]StravaRouteAPI, error) {
 	client := stravaOAuthConf.Client(ctx, &oauth2.Token{AccessToken: accessToken})
 
-	url := fmt.Sprintf("https://www.strava.com/api/v3/athletes/%d/routes?per_page=200", athleteID)
+	url := fmt.Sprintf("%s/api/v3/athletes/%d/routes?per_page=200", stravaBaseURL, athleteID)
 
 	resp, err := client.Get(url)
 	if err != nil {
@@ -385,8 +556,11 @@ func searchStravaRoutesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if !user.IsPaidMember {
-		http.Error(w, "Forbidden: Only paid members can search Strava routes", http.StatusForbidden)
+	if !user.HasCapability(capSubmitRoutes) {
+		http.Error(w, "Forbidden: Your membership doesn't include route submission", http.StatusForbidden)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
 		return
 	}
 
@@ -394,6 +568,10 @@ func searchStravaRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	accessToken, err := GetFreshStravaToken(ctx, user)
+	if errors.Is(err, errStravaAuthRevoked) {
+		writeDropdownError(w, "Reconnect Strava to load your routes")
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting fresh Strava token for search: %v", err)
 		writeDropdownError(w, "Failed to load routes")
@@ -431,8 +609,11 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized: Not logged in", http.StatusUnauthorized)
 		return
 	}
-	if !user.IsPaidMember {
-		http.Error(w, "Forbidden: Only paid members can submit or modify routes", http.StatusForbidden)
+	if !user.HasCapability(capSubmitRoutes) {
+		http.Error(w, "Forbidden: Your membership doesn't include route submission", http.StatusForbidden)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
 		return
 	}
 
@@ -444,25 +625,55 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 	selectedRouteID := r.FormValue("selectedRouteID")       // From "My Submitted Routes" dropdown
 	stravaRouteSelectID := r.FormValue("stravaRouteSelect") // From Strava API dropdown
 	routeClassify := r.FormValue("routeClassify")
+	confirmedSimilar := r.FormValue("confirmSimilar") == "yes" // Submitter was warned about similar routes and went ahead
 
 	ctx := r.Context()
-	var routeToSave *Route // Will hold the route to create or update
+	var routeToSave *Route   // Will hold the route to create or update
+	var previousRoute *Route // The existing route as loaded, when updating
+	var similarWarning *SimilarRouteWarning
+	var audit *AuditEntry // Recorded once the route is saved
+	routeChange := routeChangeReclassified
 
 	if selectedRouteID != "" {
 		// --- Scenario 1: User is re-classifying one of their existing submitted club routes ---
 		existingRoute, err := GetRouteByID(ctx, selectedRouteID)
 		if err != nil {
 			log.Printf("Error getting existing route %s for re-classification: %v", selectedRouteID, err)
-			http.Error(w, "Failed to retrieve existing route", http.StatusInternalServerError)
+			writeStoreError(w, r, "Route", err)
 			return
 		}
 
 		// Authorization check: ensure user owns this route, or is an admin
-		if existingRoute.SubmittedByUserID != strconv.FormatInt(user.StravaID, 10) && !user.IsAdmin {
+		if !existingRoute.IsSubmittedBy(user) && !user.Can(permManageRoutes) {
 			http.Error(w, "Forbidden: You can only re-classify your own routes", http.StatusForbidden)
 			return
 		}
 
+		// Changing someone else's route is a curator action and is audited
+		if !existingRoute.IsSubmittedBy(user) && existingRoute.Classify != routeClassify {
+			audit = &AuditEntry{
+				ActorID:   user.StravaID,
+				ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+				Action:    auditActionRouteEdited,
+				TargetID:  existingRoute.ID,
+				Details:   existingRoute.Name,
+				Before:    map[string]string{"classify": existingRoute.Classify},
+				After:     map[string]string{"classify": routeClassify},
+			}
+		}
+
+		// Rejections are final, but a route with changes requested goes back to the curators
+		if existingRoute.Status == routeStatusRejected && !user.Can(permManageRoutes) {
+			http.Error(w, "Forbidden: This route was rejected and can't be resubmitted", http.StatusForbidden)
+			return
+		}
+		previous := *existingRoute
+		previousRoute = &previous
+		if existingRoute.Status == routeStatusChangesRequested {
+			existingRoute.Status = routeStatusPending
+			routeChange = routeChangeResubmitted
+		}
+
 		existingRoute.Classify = routeClassify // Update classification
 		routeToSave = existingRoute            // Use existing route for update
 	} else if stravaRouteSelectID != "" {
@@ -475,13 +686,17 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Fetch the selected Strava route details using the fresh token
 		accessToken, tokenErr := GetFreshStravaToken(ctx, user)
+		if errors.Is(tokenErr, errStravaAuthRevoked) {
+			http.Error(w, "Your Strava connection has expired. Please log in with Strava again.", http.StatusUnauthorized)
+			return
+		}
 		if tokenErr != nil {
 			log.Printf("Error getting fresh Strava token for route fetch: %v", tokenErr)
 			http.Error(w, "Failed to authenticate with Strava API", http.StatusInternalServerError)
 			return
 		}
 
-		specificRouteURL := fmt.Sprintf("https://www.strava.com/api/v3/routes/%d", stravaRouteID)
+		specificRouteURL := fmt.Sprintf("%s/api/v3/routes/%d", stravaBaseURL, stravaRouteID)
 
 		client := stravaOAuthConf.Client(ctx, &oauth2.Token{AccessToken: accessToken})
 		resp, err := client.Get(specificRouteURL)
@@ -506,28 +721,39 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// --- Duplicate name check (regardless of user) ---
-		allRoutes, err := GetAllRoutes(ctx)
-		if err != nil {
-			log.Printf("Error fetching all routes for duplicate name check: %v", err)
-			http.Error(w, "Failed to check for duplicate routes", http.StatusInternalServerError)
-			return
-		}
-		for _, r := range allRoutes {
-			if strings.EqualFold(r.Name, stravaRouteDetail.Name) {
-				http.Error(w, "A route with this name already exists in the club.", http.StatusBadRequest)
-				return
-			}
-		}
-		// --- End duplicate name check ---
-
 		routeToSave = &Route{
 			Name:                stravaRouteDetail.Name,
 			URL:                 fmt.Sprintf("https://www.strava.com/routes/%d", stravaRouteDetail.ID),
-			SubmittedByUserID:   strconv.FormatInt(user.StravaID, 10),
-			SubmittedByUserName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			SubmittedByUserID:   user.StravaID,
+			SubmittedByUserName: user.DisplayName(),
 			SubmittedAt:         time.Now(),
+			Status:              routeStatusPending,
+			StravaRouteID:       stravaRouteDetail.ID,
+			Polyline:            stravaRouteDetail.Map.SummaryPolyline,
+		}
+		// Curators don't need to approve their own routes
+		if user.Can(permManageRoutes) {
+			routeToSave.Status = routeStatusApproved
+		}
+
+		// --- Similar route check (regardless of user); the submitter can go ahead once warned ---
+		if !confirmedSimilar {
+			similar, err := findSimilarRoutes(ctx, routeToSave)
+			if err != nil {
+				log.Printf("Error checking for routes similar to Strava route %d: %v", stravaRouteDetail.ID, err)
+				http.Error(w, "Failed to check for duplicate routes", http.StatusInternalServerError)
+				return
+			}
+			if len(similar) > 0 {
+				similarWarning = &SimilarRouteWarning{
+					Name:          routeToSave.Name,
+					StravaRouteID: routeToSave.StravaRouteID,
+					Classify:      routeClassify,
+					Matches:       similar,
+				}
+			}
 		}
+		// --- End similar route check ---
 	} else {
 		http.Error(w, "No route selected or invalid submission method", http.StatusBadRequest)
 		return
@@ -540,17 +766,50 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	routeToSave.Classify = routeClassify
 
-	// Save or update the route in DB
-	if err := CreateRoute(ctx, routeToSave); err != nil {
-		log.Printf("Error creating/updating route in DB: %v", err)
-		http.Error(w, "Failed to submit/update route", http.StatusInternalServerError)
-		return
-	}
+	notice := ""
+	if similarWarning != nil {
+		log.Printf("Route %s submitted by user %d looks like %d existing routes; asking for confirmation", routeToSave.Name, user.StravaID, len(similarWarning.Matches))
+	} else {
+		// Save or update the route in DB, keeping the previous version of an updated route
+		var err error
+		if previousRoute == nil {
+			// Route names are unique, so a route named like another gets a number after its name
+			if routeToSave.Name, err = uniqueRouteName(ctx, routeToSave.Name); err == nil {
+				err = CreateRoute(ctx, routeToSave)
+			}
+		} else {
+			err = UpdateRoute(ctx, previousRoute, routeToSave, user, routeChange)
+		}
+		if errors.Is(err, errRouteChanged) {
+			http.Error(w, "This route was changed while you were editing it. Please reload the page and try again.", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrDuplicate) {
+			log.Printf("Route name %q was taken while saving: %v", routeToSave.Name, err)
+			writeStoreError(w, r, "Route", err)
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating/updating route in DB: %v", err)
+			http.Error(w, "Failed to submit/update route", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Route submitted/updated by %s: %s (Classify: %s, Status: %s, ID: %s)", routeToSave.SubmittedByUserName, routeToSave.Name, routeToSave.Classify, routeToSave.StatusLabel(), routeToSave.ID)
+
+		if audit != nil {
+			if err := RecordAudit(ctx, audit); err != nil {
+				log.Printf("Error recording audit entry for route %s: %v", routeToSave.ID, err)
+			}
+		}
 
-	log.Printf("Route submitted/updated by %s: %s (Classify: %s, ID: %s)", routeToSave.SubmittedByUserName, routeToSave.Name, routeToSave.Classify, routeToSave.ID)
+		if routeToSave.Status == routeStatusPending {
+			notice = fmt.Sprintf("Thanks! %s will appear in the route library once a route curator has approved it.", routeToSave.Name)
+		}
+	}
 
-	// After submission/deletion, re-fetch all routes once and filter for user's routes for HTMX response
-	allRoutes, err := GetAllRoutes(ctx)
+	// After submission/deletion, re-fetch the route library once and filter for user's routes for HTMX response
+	allRoutes, err := GetApprovedRoutes(ctx)
 	if err != nil {
 		log.Printf("Error fetching all routes after submission: %v", err)
 		http.Error(w, "Failed to load updated routes list", http.StatusInternalServerError)
@@ -558,9 +817,8 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filteredUserRoutes := []Route{}
-	loggedInUserIDStr := strconv.FormatInt(user.StravaID, 10)
 	for _, r := range allRoutes {
-		if r.SubmittedByUserID == loggedInUserIDStr {
+		if r.IsSubmittedBy(user) {
 			filteredUserRoutes = append(filteredUserRoutes, r)
 		}
 	}
@@ -571,6 +829,9 @@ func submitRouteHandler(w http.ResponseWriter, r *http.Request) {
 		IsAdmin:    user.IsAdmin,
 		Routes:     allRoutes,
 		UserRoutes: filteredUserRoutes,
+		Notice:     notice,
+
+		SimilarRoutes: similarWarning,
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -604,26 +865,43 @@ func deleteRouteHandler(w http.ResponseWriter, r *http.Request) {
 	routeToDelete, err := GetRouteByID(ctx, routeID)
 	if err != nil {
 		log.Printf("Error getting route %s for deletion: %v", routeID, err)
-		http.Error(w, "Route not found", http.StatusNotFound)
+		writeStoreError(w, r, "Route", err)
 		return
 	}
 
 	// Authorization check: User can only delete their own route unless they are admin
-	if routeToDelete.SubmittedByUserID != strconv.FormatInt(user.StravaID, 10) && !user.IsAdmin {
+	if !routeToDelete.IsSubmittedBy(user) && !user.Can(permManageRoutes) {
 		http.Error(w, "Forbidden: You can only delete your own routes.", http.StatusForbidden)
 		return
 	}
 
-	if err := DeleteRoute(ctx, routeID); err != nil {
+	if err := SoftDeleteRoute(ctx, routeID, user, trashTime()); err != nil {
 		log.Printf("Error deleting route %s from DB: %v", routeID, err)
 		http.Error(w, "Failed to delete route from database", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Route %s deleted by user %s (Admin: %t).", routeID, user.FirstName, user.IsAdmin)
+	log.Printf("Route %s deleted by user %s (Route manager: %t).", routeID, user.FirstName, user.Can(permManageRoutes))
+
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionRouteDeleted,
+		TargetID:  routeID,
+		Details:   routeToDelete.Name,
+		Before: map[string]string{
+			"name":        routeToDelete.Name,
+			"url":         routeToDelete.URL,
+			"classify":    routeToDelete.Classify,
+			"submittedBy": strconv.FormatInt(routeToDelete.SubmittedByUserID, 10),
+		},
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for deleted route %s: %v", routeID, err)
+	}
 
-	// After deletion, re-fetch all routes once and filter for user's routes for HTMX response
-	allRoutes, err := GetAllRoutes(ctx)
+	// After deletion, re-fetch the route library once and filter for user's routes for HTMX response
+	allRoutes, err := GetApprovedRoutes(ctx)
 	if err != nil {
 		log.Printf("Error fetching all routes after deletion: %v", err)
 		http.Error(w, "Failed to load updated routes list", http.StatusInternalServerError)
@@ -631,9 +909,8 @@ func deleteRouteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filteredUserRoutes := []Route{}
-	loggedInUserIDStr := strconv.FormatInt(user.StravaID, 10)
 	for _, r := range allRoutes {
-		if r.SubmittedByUserID == loggedInUserIDStr {
+		if r.IsSubmittedBy(user) {
 			filteredUserRoutes = append(filteredUserRoutes, r)
 		}
 	}
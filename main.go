@@ -24,6 +24,10 @@ var (
 	stravaClientSecret = os.Getenv("STRAVA_CLIENT_SECRET")
 	sessionSecretKey   = os.Getenv("SESSION_SECRET_KEY")
 	oauthCallbackURL   = os.Getenv("OAUTH_CALLBACK_URL") // e.g., "https://www.southpeakscc.co.uk" or "http://localhost:8081"
+	stravaBaseURL      = os.Getenv("STRAVA_BASE_URL")    // Optional, points the app at a fake Strava server for local testing
+
+	stravaWebhookVerifyToken    = os.Getenv("STRAVA_WEBHOOK_VERIFY_TOKEN")    // Shared secret echoed back by Strava when subscribing
+	stravaWebhookSubscriptionID = os.Getenv("STRAVA_WEBHOOK_SUBSCRIPTION_ID") // Required for webhook events; events from other subscriptions are refused
 
 	store           = sessions.NewCookieStore([]byte(sessionSecretKey))
 	stravaOAuthConf *oauth2.Config
@@ -45,7 +49,30 @@ type TemplateData struct {
 	Routes           []Route // For routes page (all club routes)
 	UserRoutes       []Route // For routes page (user's own submitted routes)
 	StravaUserRoutes string
-	CSSVersion       string // Add this line
+	CSSVersion       string            // Add this line
+	Renewals         *RenewalDashboard // For the admin renewal dashboard
+	PaymentsEnabled  bool              // Online checkout is available on the members page
+	TierOptions      []TierOption      // Tiers offered to the member at checkout
+	Tiers            []MembershipTier  // For the admin tier editor and renewal forms
+	Capabilities     []Capability      // For the admin tier editor
+	Roles            []Role            // For the admin roles page
+	AuditEntries     []AuditEntry      // For the admin audit log
+	AuditHasMore     bool              // There are older audit entries on the next page
+	AuditFilter      *AuditFilter
+	AuditActions     []string
+	Stats            *DashboardStats      // For the admin dashboard
+	RosterPreview    *RosterPreview       // Dry run of a roster CSV import
+	Notice           string               // Confirmation shown above a re-rendered fragment
+	Error            string               // Shown by error_fragment.html when an HTMX request fails
+	EmailEnabled     bool                 // A mailer is configured, so email addresses can be verified
+	Onboarding       *OnboardingView      // For the onboarding wizard
+	Rides            []Ride               // For the rides page (upcoming rides)
+	RideSheet        *RideSheet           // For the printable ride sheet
+	RouteDetail      *RouteDetail         // For the route detail page
+	Trash            *TrashView           // For the admin trash page
+	SimilarRoutes    *SimilarRouteWarning // Routes like the one being submitted, for the submitter to confirm
+
+	TrashRetentionDays int // How long deleted accounts are kept before they're purged, for the members' deletion notices
 }
 
 var tmpl *template.Template
@@ -56,14 +83,18 @@ func main() {
 		log.Fatal("Missing environment variables: STRAVA_CLIENT_ID, STRAVA_CLIENT_SECRET, SESSION_SECRET_KEY, OAUTH_CALLBACK_URL")
 	}
 
+	if stravaBaseURL == "" {
+		stravaBaseURL = "https://www.strava.com"
+	}
+
 	stravaOAuthConf = &oauth2.Config{
 		ClientID:     stravaClientID,
 		ClientSecret: stravaClientSecret,
 		RedirectURL:  oauthCallbackURL + "/auth/strava/callback",
 		Scopes:       []string{"read_all"},
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://www.strava.com/oauth/authorize",
-			TokenURL: "https://www.strava.com/oauth/token",
+			AuthURL:  stravaBaseURL + "/oauth/authorize",
+			TokenURL: stravaBaseURL + "/oauth/token",
 		},
 	}
 
@@ -88,8 +119,69 @@ func main() {
 		}
 	}()
 
+	paymentProvider, err = newPaymentProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure payments: %v", err)
+	}
+	if paymentProvider != nil {
+		log.Printf("Online payments enabled via %s", paymentProvider.Name())
+	}
+
+	mailer, err = newMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure email: %v", err)
+	}
+	if mailer != nil {
+		log.Printf("Email enabled via %s", mailer.Name())
+	}
+
+	trashRetention, err = trashRetentionFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure the trash: %v", err)
+	}
+
+	// Admin commands (e.g. `go run . strava-subscription list`) run instead of the web server
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:]); err != nil {
+			log.Fatalf("Command failed: %v", err)
+		}
+		return
+	}
+
+	// Bring the database up to date, see migrations.go. `go run . migrate` does the same without
+	// starting the server.
+	if count, err := runMigrations(ctx); err != nil {
+		log.Fatalf("Failed to migrate the database: %v", err)
+	} else if count > 0 {
+		log.Printf("Ran %d database migrations", count)
+	}
+
+	// ADMIN_STRAVA_IDS are always admins
+	if count, err := bootstrapAdmins(ctx); err != nil {
+		log.Fatalf("Failed to bootstrap admins: %v", err)
+	} else if count > 0 {
+		log.Printf("Gave the admin role to %d users from ADMIN_STRAVA_IDS", count)
+	}
+
+	if err := loadTiers(ctx); err != nil {
+		log.Fatalf("Failed to load membership tiers: %v", err)
+	}
+
 	// Parse templates - will parse all HTML files in templates directory
 	tmpl = template.Must(template.ParseGlob(filepath.Join("templates", "*.html")))
+	if err := loadEmailTemplates(); err != nil {
+		log.Fatalf("Failed to load email templates: %v", err)
+	}
+
+	// Background workers run until the server shuts down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	startStravaTokenRefresher(workerCtx)
+	startMembershipExpiryWorker(workerCtx)
+	startTrashPurgeWorker(workerCtx)
+	if mailer != nil {
+		startOutboxWorker(workerCtx)
+	}
 
 	mux := http.NewServeMux() // Use a new ServeMux for better control
 	fs := http.FileServer(http.Dir("static"))
@@ -103,10 +195,55 @@ func main() {
 	mux.HandleFunc("/members", membersHandler)
 	mux.HandleFunc("/admin/toggle-paid", adminTogglePaidHandler)
 	mux.HandleFunc("/members/delete-account", deleteAccountHandler)
+	mux.HandleFunc("/members/export", memberExportHandler)
+	mux.HandleFunc("/members/privacy", privacySettingsHandler)
+	mux.HandleFunc("/members/email", emailSettingsHandler)
+	mux.HandleFunc("/members/email/verify", emailVerifyHandler)
+	mux.HandleFunc("/welcome", onboardingHandler)
+	mux.HandleFunc("/admin/members/export", adminMemberExportHandler)
+	mux.HandleFunc("/admin/renewals", adminRenewalsHandler)
+	mux.HandleFunc("/admin/renewals/record", adminRecordRenewalHandler)
+	mux.HandleFunc("/admin", adminDashboardHandler)
+	mux.HandleFunc("/admin/members", adminMembersHandler)
+	mux.HandleFunc("/admin/members/search", adminMembersSearchHandler)
+	mux.HandleFunc("/admin/members/bulk", adminBulkMembersHandler)
+	mux.HandleFunc("/admin/roster", adminRosterHandler)
+	mux.HandleFunc("/admin/roster/export", adminRosterExportHandler)
+	mux.HandleFunc("/admin/roster/import", adminRosterImportHandler)
+	mux.HandleFunc("/admin/roster/apply", adminRosterApplyHandler)
+	mux.HandleFunc("/admin/routes", adminRouteQueueHandler)
+	mux.HandleFunc("/admin/routes/review", adminReviewRouteHandler)
+	mux.HandleFunc("/admin/audit", adminAuditHandler)
+	mux.HandleFunc("/admin/audit/export", adminAuditExportHandler)
+	mux.HandleFunc("/admin/roles", adminRolesHandler)
+	mux.HandleFunc("/admin/roles/update", adminUpdateRolesHandler)
+	mux.HandleFunc("/admin/tiers", adminTiersHandler)
+	mux.HandleFunc("/admin/tiers/save", adminSaveTierHandler)
+	mux.HandleFunc("/admin/trash", adminTrashHandler)
+	mux.HandleFunc("/admin/trash/action", adminTrashActionHandler)
+	mux.HandleFunc("/payments/checkout", checkoutHandler)
+	mux.HandleFunc("/payments/webhook", paymentWebhookHandler)
+	mux.HandleFunc("/payments/success", paymentSuccessHandler)
+	mux.HandleFunc("/payments/fake/checkout", fakeCheckoutHandler)
+	mux.HandleFunc("/account-deleted", accountDeletedHandler)
+	mux.HandleFunc("/rides", ridesHandler)
+	mux.HandleFunc("/rides/create", createRideHandler)
+	mux.HandleFunc("/rides/rsvp", rideRSVPHandler)
+	mux.HandleFunc("/rides/sheet", rideSheetHandler)
 	mux.HandleFunc("/routes", routesHandler)
+	mux.HandleFunc("/routes/view", routeDetailHandler)
+	mux.HandleFunc("/routes/restore", restoreRouteRevisionHandler)
 	mux.HandleFunc("/routes/submit", submitRouteHandler)
 	mux.HandleFunc("/routes/delete", deleteRouteHandler)
 	mux.HandleFunc("/routes/search-strava", searchStravaRoutesHandler)
+	// Webhook events are unsigned, so they're only accepted once the club's subscription ID is
+	// configured. Until then only the validation needed to create the subscription is answered.
+	if stravaWebhookSubscriptionID != "" {
+		mux.HandleFunc("/webhooks/strava", stravaWebhookHandler)
+	} else {
+		log.Printf("STRAVA_WEBHOOK_SUBSCRIPTION_ID not set, Strava webhook events will be refused")
+		mux.HandleFunc("/webhooks/strava", stravaWebhookValidationHandler)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -140,6 +277,7 @@ func main() {
 	defer cancel()
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
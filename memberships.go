@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Membership records a member's subscription for one club season
+type Membership struct {
+	Season      int       `bson:"season"`      // Club season, e.g. 2025. Seasons run January to December.
+	Type        string    `bson:"type"`        // e.g. "standard"
+	AmountPence int       `bson:"amountPence"` // Amount paid, in pence
+	PaidAt      time.Time `bson:"paidAt"`
+	StartsAt    time.Time `bson:"startsAt"` // Later of the payment and the season start, so early renewals don't overlap
+	ExpiresAt   time.Time `bson:"expiresAt"`
+	RecordedBy  int64     `bson:"recordedBy"`           // StravaID of the admin who recorded it, 0 if recorded automatically
+	PaymentRef  string    `bson:"paymentRef,omitempty"` // Online checkout session ID, if paid on the site
+}
+
+const (
+	membershipExpiryInterval = time.Hour           // How often lapsed memberships are expired
+	renewalReminderWindow    = 30 * 24 * time.Hour // "Expiring soon" on the renewal dashboard
+)
+
+// seasonFor returns the club season a moment falls in
+func seasonFor(t time.Time) int {
+	return t.Year()
+}
+
+// seasonStart returns the moment memberships for a season begin
+func seasonStart(season int) time.Time {
+	return time.Date(season, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// seasonEnd returns the moment memberships for a season expire
+func seasonEnd(season int) time.Time {
+	return seasonStart(season + 1)
+}
+
+// newSeasonMembership builds a membership for the given season paid now
+func newSeasonMembership(season int, membershipType string, amountPence int, recordedBy int64) Membership {
+	now := time.Now()
+	startsAt := seasonStart(season)
+	if now.After(startsAt) {
+		startsAt = now
+	}
+	return Membership{
+		Season:      season,
+		Type:        membershipType,
+		AmountPence: amountPence,
+		PaidAt:      now,
+		StartsAt:    startsAt,
+		ExpiresAt:   seasonEnd(season),
+		RecordedBy:  recordedBy,
+	}
+}
+
+// IsValidAt reports whether the membership covers the given moment
+func (m Membership) IsValidAt(t time.Time) bool {
+	return !t.Before(m.StartsAt) && t.Before(m.ExpiresAt)
+}
+
+// AmountPounds formats the amount paid for display
+func (m Membership) AmountPounds() string {
+	return fmt.Sprintf("£%d.%02d", m.AmountPence/100, m.AmountPence%100)
+}
+
+// CurrentMembership returns the membership covering the given moment, or nil if there is none
+func (u *User) CurrentMembership(t time.Time) *Membership {
+	var current *Membership
+	for i := range u.Memberships {
+		m := &u.Memberships[i]
+		if m.IsValidAt(t) && (current == nil || m.ExpiresAt.After(current.ExpiresAt)) {
+			current = m
+		}
+	}
+	return current
+}
+
+// seasonCutoff is the moment a membership for the season must still be running at to count as
+// paid for it: now for current and future seasons, and the season's end for past ones, so a
+// membership that ran its course still counts but one an admin ended early doesn't.
+func seasonCutoff(season int, now time.Time) time.Time {
+	if end := seasonEnd(season); end.Before(now) {
+		return end
+	}
+	return now
+}
+
+// MembershipForSeason returns the member's membership for a season, or nil if they haven't paid
+// for it or it has been ended
+func (u *User) MembershipForSeason(season int, now time.Time) *Membership {
+	cutoff := seasonCutoff(season, now)
+	for i := range u.Memberships {
+		m := &u.Memberships[i]
+		if m.Season == season && !m.ExpiresAt.Before(cutoff) {
+			return m
+		}
+	}
+	return nil
+}
+
+// derivePaidStatus sets IsPaidMember from the member's memberships. The stored isPaidMember
+// field is only a cache for queries; the memberships are the source of truth.
+func derivePaidStatus(u *User) {
+	u.IsPaidMember = u.CurrentMembership(time.Now()) != nil
+}
+
+// AddMembershipForPayment records a membership paid online unless the same payment was already
+// recorded, reporting whether it was added. The check and the push happen in a single update.
+func AddMembershipForPayment(ctx context.Context, stravaID int64, m Membership) (bool, error) {
+	filter := bson.M{"stravaID": stravaID, "memberships.paymentRef": bson.M{"$ne": m.PaymentRef}}
+	update := bson.M{"$push": bson.M{"memberships": m}}
+	if m.IsValidAt(time.Now()) {
+		update["$set"] = bson.M{"isPaidMember": true}
+	}
+	res, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to add paid membership for user %d: %w", stravaID, err)
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// EndCurrentMemberships cuts short any membership of the user that is still running, marking them unpaid
+func EndCurrentMemberships(ctx context.Context, stravaID int64) error {
+	if _, err := EndCurrentMembershipsForUsers(ctx, []int64{stravaID}); err != nil {
+		return fmt.Errorf("failed to end memberships for user %d: %w", stravaID, err)
+	}
+	return nil
+}
+
+// EndCurrentMembershipsForUsers cuts short the running memberships of every listed user in a
+// single update, returning how many users were paid members.
+func EndCurrentMembershipsForUsers(ctx context.Context, stravaIDs []int64) (int64, error) {
+	now := time.Now()
+	filter := bson.M{"stravaID": bson.M{"$in": stravaIDs}}
+	update := bson.M{"$set": bson.M{
+		"memberships.$[running].expiresAt": now,
+		"isPaidMember":                     false,
+	}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"running.expiresAt": bson.M{"$gt": now}}},
+	})
+	res, err := mongoDB.Collection(usersCollection).UpdateMany(ctx, excludeDeleted(filter), update, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to end memberships: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// AddSeasonMembership records the membership for the user unless they already have one for its
+// season that hasn't been ended, reporting whether it was added
+func AddSeasonMembership(ctx context.Context, stravaID int64, m Membership) (bool, error) {
+	added, err := AddSeasonMemberships(ctx, []int64{stravaID}, m)
+	if err != nil {
+		return false, fmt.Errorf("failed to add membership for user %d: %w", stravaID, err)
+	}
+	return added == 1, nil
+}
+
+// AddSeasonMemberships records the membership for every listed user who doesn't already have a
+// membership for its season that hasn't been ended, in a single update. It returns how many were added.
+func AddSeasonMemberships(ctx context.Context, stravaIDs []int64, m Membership) (int64, error) {
+	filter := bson.M{
+		"stravaID": bson.M{"$in": stravaIDs},
+		"memberships": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"season":    m.Season,
+			"expiresAt": bson.M{"$gte": seasonCutoff(m.Season, time.Now())},
+		}}},
+	}
+	update := bson.M{"$push": bson.M{"memberships": m}}
+	if m.IsValidAt(time.Now()) {
+		update["$set"] = bson.M{"isPaidMember": true}
+	}
+	res, err := mongoDB.Collection(usersCollection).UpdateMany(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add season %d memberships: %w", m.Season, err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// SyncMembershipStatuses brings the cached paid flag in line with memberships: it clears it for
+// users whose membership has lapsed and sets it for users whose early renewal has started.
+func SyncMembershipStatuses(ctx context.Context) (expired, activated int64, err error) {
+	now := time.Now()
+	current := bson.M{"$elemMatch": bson.M{
+		"startsAt":  bson.M{"$lte": now},
+		"expiresAt": bson.M{"$gt": now},
+	}}
+	coll := mongoDB.Collection(usersCollection)
+
+	res, err := coll.UpdateMany(ctx,
+		bson.M{"isPaidMember": true, "memberships": bson.M{"$not": current}},
+		bson.M{"$set": bson.M{"isPaidMember": false}})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to expire lapsed memberships: %w", err)
+	}
+	expired = res.ModifiedCount
+
+	res, err = coll.UpdateMany(ctx,
+		bson.M{"isPaidMember": bson.M{"$ne": true}, "memberships": current},
+		bson.M{"$set": bson.M{"isPaidMember": true}})
+	if err != nil {
+		return expired, 0, fmt.Errorf("failed to activate started memberships: %w", err)
+	}
+	return expired, res.ModifiedCount, nil
+}
+
+// backfillLegacyMemberships gives users marked paid before membership records existed a
+// membership for the current season, so deriving paid status doesn't silently unpay them.
+func backfillLegacyMemberships(ctx context.Context) (int64, error) {
+	filter := bson.M{
+		"isPaidMember": true,
+		"$or": bson.A{
+			bson.M{"memberships": bson.M{"$exists": false}},
+			bson.M{"memberships": bson.M{"$size": 0}},
+			bson.M{"memberships": nil},
+		},
+	}
+	m := newSeasonMembership(seasonFor(time.Now()), "legacy", 0, 0)
+	update := bson.M{"$set": bson.M{"memberships": bson.A{m}}}
+	res, err := mongoDB.Collection(usersCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill legacy memberships: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// startMembershipExpiryWorker syncs membership statuses periodically until ctx is cancelled
+func startMembershipExpiryWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(membershipExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			expired, activated, err := SyncMembershipStatuses(ctx)
+			if err != nil {
+				log.Printf("Error syncing membership statuses: %v", err)
+			} else if expired > 0 || activated > 0 {
+				log.Printf("Membership statuses synced: %d expired, %d activated", expired, activated)
+			}
+			if err := remindExpiringMemberships(ctx, time.Now()); err != nil {
+				log.Printf("Error sending membership expiry reminders: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Println("Membership expiry worker stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RenewalRow is one member on the renewal dashboard
+type RenewalRow struct {
+	User     User
+	Current  *Membership // Membership for the dashboard's season, if any
+	Previous *Membership // Most recent membership from an earlier season, if any
+}
+
+// RenewalDashboard groups members by their renewal state for a season
+type RenewalDashboard struct {
+	Season       int
+	SeasonEndsAt time.Time
+	ExpiringSoon bool // The season ends within renewalReminderWindow
+	Renewed      []RenewalRow
+	Lapsed       []RenewalRow // Paid for an earlier season but not this one
+	NeverPaid    []RenewalRow
+}
+
+// PreviousSeason is the season before the dashboard's, for navigation
+func (d *RenewalDashboard) PreviousSeason() int { return d.Season - 1 }
+
+// NextSeason is the season after the dashboard's, for navigation
+func (d *RenewalDashboard) NextSeason() int { return d.Season + 1 }
+
+// buildRenewalDashboard sorts members into renewed, lapsed and never-paid for the season
+func buildRenewalDashboard(members []User, season int) *RenewalDashboard {
+	now := time.Now()
+	untilEnd := time.Until(seasonEnd(season))
+	dashboard := &RenewalDashboard{
+		Season:       season,
+		SeasonEndsAt: seasonEnd(season),
+		ExpiringSoon: untilEnd > 0 && untilEnd < renewalReminderWindow,
+	}
+
+	for _, member := range members {
+		row := RenewalRow{User: member, Current: member.MembershipForSeason(season, now)}
+		for i := range member.Memberships {
+			m := &member.Memberships[i]
+			if m.Season < season && (row.Previous == nil || m.Season > row.Previous.Season) {
+				row.Previous = m
+			}
+		}
+
+		switch {
+		case row.Current != nil:
+			dashboard.Renewed = append(dashboard.Renewed, row)
+		case row.Previous != nil:
+			dashboard.Lapsed = append(dashboard.Lapsed, row)
+		default:
+			dashboard.NeverPaid = append(dashboard.NeverPaid, row)
+		}
+	}
+
+	// Most recently lapsed first, since they are the likeliest to renew
+	sort.SliceStable(dashboard.Lapsed, func(i, j int) bool {
+		return dashboard.Lapsed[i].Previous.Season > dashboard.Lapsed[j].Previous.Season
+	})
+	return dashboard
+}
+
+// adminRenewalsHandler shows the renewal dashboard for a season (defaults to the current one)
+func adminRenewalsHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	season := seasonFor(time.Now())
+	if s := r.URL.Query().Get("season"); s != "" {
+		parsed, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid season", http.StatusBadRequest)
+			return
+		}
+		season = parsed
+	}
+
+	members, err := GetAllUsers(r.Context())
+	if err != nil {
+		log.Printf("Error fetching members for renewal dashboard: %v", err)
+		http.Error(w, "Failed to load members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Renewals:    buildRenewalDashboard(members, season),
+		Tiers:       allTiers(),
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_renewals.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_renewals template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminRecordRenewalHandler records a paid membership for a member and re-renders the renewal tables
+func adminRecordRenewalHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(r.FormValue("userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	season, err := strconv.Atoi(r.FormValue("season"))
+	if err != nil {
+		http.Error(w, "Invalid season", http.StatusBadRequest)
+		return
+	}
+	// Admins can record any tier, including ones members can't buy themselves
+	tier := tierByKey(r.FormValue("tier"))
+	if tier == nil {
+		tier = defaultTier()
+	}
+	if tier == nil {
+		http.Error(w, "No membership tiers configured", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	targetUser, err := GetUserByID(ctx, targetUserID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", targetUserID, err)
+		writeStoreError(w, r, "User", err)
+		return
+	}
+
+	// Recording the same season twice (e.g. a double click) is a no-op
+	m := newSeasonMembership(season, tier.Key, tier.PricePence, user.StravaID)
+	added, err := AddSeasonMembership(ctx, targetUserID, m)
+	if err != nil {
+		log.Printf("Error recording renewal for user %d: %v", targetUserID, err)
+		http.Error(w, "Failed to record renewal", http.StatusInternalServerError)
+		return
+	}
+	if added {
+		log.Printf("%s renewal for season %d recorded for user %d by %s", tier.Name, season, targetUserID, user.FirstName)
+
+		audit := &AuditEntry{
+			ActorID:   user.StravaID,
+			ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			Action:    auditActionRenewalAdded,
+			TargetID:  strconv.FormatInt(targetUserID, 10),
+			Details:   fmt.Sprintf("%s %s", targetUser.FirstName, targetUser.LastName),
+			After: map[string]string{
+				"season": strconv.Itoa(season),
+				"tier":   tier.Key,
+				"amount": m.AmountPounds(),
+			},
+		}
+		if err := RecordAudit(ctx, audit); err != nil {
+			log.Printf("Error recording audit entry for renewal of user %d: %v", targetUserID, err)
+		}
+	}
+
+	members, err := GetAllUsers(ctx)
+	if err != nil {
+		log.Printf("Error fetching members after renewal: %v", err)
+		http.Error(w, "Failed to load updated members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		IsAdmin:  user.IsAdmin,
+		Renewals: buildRenewalDashboard(members, season),
+		Tiers:    allTiers(),
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "renewals_table_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing renewals_table_fragment template: %v", err)
+		http.Error(w, "Failed to render updated renewals", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a versioned change to the database schema or data. Every migration must be safe
+// to run more than once, as two servers starting together may both run it before either records it.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+// appliedMigration records a migration that has run
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+const migrationsCollection = "migrations" // MongoDB collection name
+
+// migrations run in order of version. Never change or renumber a migration once it's been
+// deployed; add a new one instead.
+var migrations = []Migration{
+	{1, "Give members marked paid before membership records existed a membership",
+		countMigration(backfillLegacyMemberships, "Backfilled memberships for %d legacy paid members")},
+	{2, "Give routes from before revisions were kept a createdAt and updatedAt",
+		countMigration(backfillRouteTimestamps, "Backfilled timestamps for %d routes")},
+	{3, "Give routes from before duplicate detection their Strava route ID",
+		countMigration(backfillRouteStravaIDs, "Backfilled Strava IDs for %d routes")},
+	{4, "Give admins made by setting isAdmin by hand the admin role",
+		countMigration(migrateLegacyAdmins, "Gave the admin role to %d legacy admins")},
+	{5, "Index the email outbox by dedupe key", ensureOutboxIndexes},
+	{6, "Seed the default membership tiers", seedDefaultTiers},
+	{7, "Remove duplicate users and index users by Strava ID", ensureUserIndexes},
+	{8, "Index routes by submitter, Strava route ID and bounds, and make route names unique", ensureRouteIndexes},
+	{9, "Refer to route submitters by numeric Strava ID and stop storing their names",
+		countMigration(migrateRouteSubmitterIDs, "Converted submitter IDs in %d routes")},
+	{10, "Give routes from before bounds were stored the bounding box of their track",
+		countMigration(backfillRouteBounds, "Backfilled bounds for %d routes")},
+}
+
+// countMigration adapts a data migration that reports how many documents it changed
+func countMigration(migrate func(context.Context) (int64, error), format string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		count, err := migrate(ctx)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			log.Printf(format, count)
+		}
+		return nil
+	}
+}
+
+// getAppliedMigrations retrieves the versions of the migrations that have run
+func getAppliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	cursor, err := mongoDB.Collection(migrationsCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("error finding applied migrations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("error decoding applied migrations: %w", err)
+	}
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// runMigrations runs the migrations that haven't been applied yet, in order, returning how many
+// ran. It stops at the first that fails, so later migrations can rely on earlier ones.
+func runMigrations(ctx context.Context) (int, error) {
+	applied, err := getAppliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Printf("Running migration %d: %s", m.Version, m.Description)
+		if err := m.Up(ctx); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		// Upsert rather than insert, in case another server ran the same migration meanwhile
+		record := appliedMigration{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		opts := options.Replace().SetUpsert(true)
+		if _, err := mongoDB.Collection(migrationsCollection).ReplaceOne(ctx, bson.M{"_id": m.Version}, record, opts); err != nil {
+			return ran, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		ran++
+	}
+	return ran, nil
+}
+
+// runMigrateCommand runs pending migrations, or lists them all with `migrate status`
+func runMigrateCommand(ctx context.Context, args []string) error {
+	const usage = "usage: migrate [status]"
+	if len(args) > 1 || (len(args) == 1 && args[0] != "status") {
+		return errors.New(usage)
+	}
+
+	if len(args) == 1 {
+		applied, err := getAppliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := "pending"
+			if record, ok := applied[m.Version]; ok {
+				status = "applied " + record.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\t%s\n", m.Version, status, m.Description)
+		}
+		return nil
+	}
+
+	ran, err := runMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if ran == 0 {
+		fmt.Println("The database is up to date")
+	} else {
+		fmt.Printf("Ran %d migrations\n", ran)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Notification kinds, recorded on outbox messages
+const (
+	notifyWelcome            = "welcome"
+	notifyMembershipExpiring = "membership_expiring"
+	notifyRouteApproved      = "route_approved"
+	notifyRouteRejected      = "route_rejected"
+	notifyRouteChanges       = "route_changes_requested"
+	notifyVerifyEmail        = "verify_email"
+	notifyAnnouncement       = "announcement" // Sent by an admin to selected members
+)
+
+// queueNotification renders an email for a member and adds it to the outbox. It does nothing when
+// email is disabled or the member has no verified address.
+func queueNotification(ctx context.Context, u *User, kind, dedupeKey, subject string, data EmailData) error {
+	if u.Email == "" {
+		return nil
+	}
+	return queueEmail(ctx, u, u.Email, kind, dedupeKey, subject, data)
+}
+
+// queueEmail renders an email for a member and adds it to the outbox, addressed to to
+func queueEmail(ctx context.Context, u *User, to, kind, dedupeKey, subject string, data EmailData) error {
+	if mailer == nil {
+		return nil
+	}
+
+	data.SiteURL = oauthCallbackURL
+	data.FirstName = u.FirstName
+	data.CurrentYear = time.Now().Year()
+	msg, err := renderEmail(kind, to, subject, data)
+	if err != nil {
+		return err
+	}
+
+	queued, err := EnqueueEmail(ctx, &OutboxMessage{
+		Kind:      kind,
+		DedupeKey: dedupeKey,
+		UserID:    u.StravaID,
+		To:        msg.To,
+		Subject:   msg.Subject,
+		HTMLBody:  msg.HTMLBody,
+		TextBody:  msg.TextBody,
+	})
+	if err != nil {
+		return err
+	}
+	if queued {
+		log.Printf("Queued %s email for user %d", kind, u.StravaID)
+	}
+	return nil
+}
+
+// sendWelcomeEmail welcomes a new member to the club. It is only ever sent once per member.
+func sendWelcomeEmail(ctx context.Context, u *User) error {
+	return queueNotification(ctx, u, notifyWelcome, fmt.Sprintf("%s:%d", notifyWelcome, u.StravaID),
+		"Welcome to South Peaks Cycling Club", EmailData{})
+}
+
+// sendMembershipExpiringEmail reminds a member to renew. It is sent once per member per season.
+func sendMembershipExpiringEmail(ctx context.Context, u *User, m *Membership) error {
+	return queueNotification(ctx, u, notifyMembershipExpiring, fmt.Sprintf("%s:%d:%d", notifyMembershipExpiring, u.StravaID, m.Season),
+		fmt.Sprintf("Your %d SPCC membership is expiring", m.Season), EmailData{Season: m.Season, ExpiresAt: m.ExpiresAt})
+}
+
+// sendRouteApprovedEmail tells a member a route they submitted is now in the route library
+func sendRouteApprovedEmail(ctx context.Context, u *User, route *Route) error {
+	return queueNotification(ctx, u, notifyRouteApproved, fmt.Sprintf("%s:%s", notifyRouteApproved, route.ID),
+		fmt.Sprintf("Your route %q has been approved", route.Name), EmailData{RouteName: route.Name, RouteURL: route.URL})
+}
+
+// sendRouteReviewedEmail tells a member what a route curator decided about a route they submitted.
+// A route can be reviewed more than once if its submitter resubmits it after changes, so only the
+// approval is deduplicated.
+func sendRouteReviewedEmail(ctx context.Context, u *User, route *Route) error {
+	data := EmailData{RouteName: route.Name, RouteURL: route.URL, Note: route.ReviewNote}
+	switch route.Status {
+	case routeStatusApproved:
+		return sendRouteApprovedEmail(ctx, u, route)
+	case routeStatusRejected:
+		return queueNotification(ctx, u, notifyRouteRejected, "", fmt.Sprintf("Your route %q wasn't approved", route.Name), data)
+	case routeStatusChangesRequested:
+		return queueNotification(ctx, u, notifyRouteChanges, "", fmt.Sprintf("Changes requested for your route %q", route.Name), data)
+	}
+	return nil
+}
+
+// remindExpiringMemberships emails members whose membership ends within renewalReminderWindow
+// and who haven't yet paid for the following season
+func remindExpiringMemberships(ctx context.Context, now time.Time) error {
+	if mailer == nil {
+		return nil
+	}
+	window := bson.M{"$gt": now, "$lte": now.Add(renewalReminderWindow)}
+	filter := bson.M{"email": bson.M{"$nin": bson.A{"", nil}}, "memberships": bson.M{"$elemMatch": bson.M{"expiresAt": window}}}
+	members, err := findUsers(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to find members with expiring memberships: %w", err)
+	}
+
+	for i := range members {
+		member := &members[i]
+		for j := range member.Memberships {
+			m := &member.Memberships[j]
+			if !m.ExpiresAt.After(now) || m.ExpiresAt.After(now.Add(renewalReminderWindow)) {
+				continue
+			}
+			if member.MembershipForSeason(m.Season+1, now) != nil {
+				continue // Already renewed
+			}
+			if err := sendMembershipExpiringEmail(ctx, member, m); err != nil {
+				log.Printf("Error queueing expiry reminder for user %d: %v", member.StravaID, err)
+			}
+		}
+	}
+	return nil
+}
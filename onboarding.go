@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// clubRulesVersion identifies the club rules shown in the onboarding wizard. Changing the rules
+// means bumping it, and every member is asked to accept the new version before they can use
+// routes or rides again.
+const clubRulesVersion = "2025.1"
+
+// MemberProfile is what a member tells the club during onboarding, beyond what Strava provides.
+// Their date of birth is User.DateOfBirth, as tier eligibility predates the profile.
+type MemberProfile struct {
+	Phone            string           `bson:"phone"`
+	EmergencyContact EmergencyContact `bson:"emergencyContact"`
+
+	MedicalNotes      string    `bson:"medicalNotes"`      // Only shown to ride leaders, see permViewMedicalNotes
+	MedicalAnsweredAt time.Time `bson:"medicalAnsweredAt"` // When the member last saved the medical step, even if they left it blank
+
+	PhotoConsent    bool      `bson:"photoConsent"`    // May appear in club photos on social media
+	PhotoAnsweredAt time.Time `bson:"photoAnsweredAt"` // Zero until the member has answered
+
+	RulesVersion    string    `bson:"rulesVersion"` // Version of the club rules accepted, see clubRulesVersion
+	RulesAcceptedAt time.Time `bson:"rulesAcceptedAt"`
+}
+
+// EmergencyContact is who ride leaders call if a member has an accident
+type EmergencyContact struct {
+	Name         string `bson:"name"`
+	Relationship string `bson:"relationship"`
+	Phone        string `bson:"phone"`
+}
+
+// Onboarding steps, in order
+const (
+	onboardingContact   = "contact"
+	onboardingEmergency = "emergency"
+	onboardingMedical   = "medical"
+	onboardingPhotos    = "photos"
+	onboardingRules     = "rules"
+)
+
+// onboardingSteps are the wizard's steps with their titles
+var onboardingSteps = []struct {
+	Key   string
+	Title string
+}{
+	{onboardingContact, "Contact Details"},
+	{onboardingEmergency, "Emergency Contact"},
+	{onboardingMedical, "Medical Information"},
+	{onboardingPhotos, "Photo Consent"},
+	{onboardingRules, "Club Rules"},
+}
+
+const (
+	maxProfileTextLength  = 100
+	maxMedicalNotesLength = 2000
+	onboardingDateLayout  = "2006-01-02"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()-]{6,20}$`)
+
+// onboardingStepDone reports whether the member has completed a step
+func (u *User) onboardingStepDone(step string) bool {
+	p := &u.Profile
+	switch step {
+	case onboardingContact:
+		return p.Phone != "" && !u.DateOfBirth.IsZero()
+	case onboardingEmergency:
+		return p.EmergencyContact.Name != "" && p.EmergencyContact.Phone != ""
+	case onboardingMedical:
+		return !p.MedicalAnsweredAt.IsZero()
+	case onboardingPhotos:
+		return !p.PhotoAnsweredAt.IsZero()
+	case onboardingRules:
+		return p.RulesVersion == clubRulesVersion
+	}
+	return false
+}
+
+// NextOnboardingStep is the first step the member hasn't completed, or "" if they've finished
+func (u *User) NextOnboardingStep() string {
+	for _, step := range onboardingSteps {
+		if !u.onboardingStepDone(step.Key) {
+			return step.Key
+		}
+	}
+	return ""
+}
+
+// OnboardingComplete reports whether the member has completed every onboarding step, including
+// accepting the current club rules. Routes and rides are only available once they have.
+func (u *User) OnboardingComplete() bool {
+	return u.NextOnboardingStep() == ""
+}
+
+// OnboardingStep is a step as shown in the wizard's progress list
+type OnboardingStep struct {
+	Key     string
+	Title   string
+	Done    bool
+	Current bool
+}
+
+// OnboardingView is the onboarding wizard's state for the template
+type OnboardingView struct {
+	Step         string
+	Steps        []OnboardingStep
+	Error        string
+	RulesVersion string
+}
+
+// DateOfBirthValue formats the member's date of birth for a date input
+func (u *User) DateOfBirthValue() string {
+	if u.DateOfBirth.IsZero() {
+		return ""
+	}
+	return u.DateOfBirth.Format(onboardingDateLayout)
+}
+
+// UpdateMemberProfile saves a member's profile and date of birth
+func UpdateMemberProfile(ctx context.Context, stravaID int64, profile MemberProfile, dateOfBirth time.Time) error {
+	filter := bson.M{"stravaID": stravaID}
+	update := bson.M{"$set": bson.M{"profile": profile, "dateOfBirth": dateOfBirth}}
+	result, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return fmt.Errorf("failed to update profile for user %d: %w", stravaID, err)
+	}
+	if result.MatchedCount == 0 {
+		return notFoundError("user", stravaID)
+	}
+	return nil
+}
+
+// requireOnboarding sends a member who hasn't finished onboarding to the wizard, returning false.
+// HTMX requests get an error instead, as a redirect would be swapped into the page.
+func requireOnboarding(w http.ResponseWriter, r *http.Request, user *User) bool {
+	if user.OnboardingComplete() {
+		return true
+	}
+	if r.Header.Get("HX-Request") == "true" || r.Method != http.MethodGet {
+		http.Error(w, "Forbidden: Finish setting up your membership first", http.StatusForbidden)
+		return false
+	}
+	http.Redirect(w, r, "/welcome", http.StatusFound)
+	return false
+}
+
+// onboardingHandler shows a step of the onboarding wizard and saves it when submitted, moving on
+// to the next unfinished step. Members can come back to any step later to update their details.
+func onboardingHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+
+	step := r.FormValue("step")
+	if step == "" {
+		if step = user.NextOnboardingStep(); step == "" {
+			http.Redirect(w, r, "/members", http.StatusFound)
+			return
+		}
+	}
+	if onboardingStepTitle(step) == "" {
+		http.Error(w, "Unknown onboarding step", http.StatusNotFound)
+		return
+	}
+
+	var formError string
+	if r.Method == http.MethodPost {
+		var profile MemberProfile
+		var dateOfBirth time.Time
+		profile, dateOfBirth, formError = onboardingStepFromForm(r, step, user, time.Now())
+		if formError == "" {
+			if err := UpdateMemberProfile(r.Context(), user.StravaID, profile, dateOfBirth); err != nil {
+				log.Printf("Error saving onboarding step %s for user %d: %v", step, user.StravaID, err)
+				http.Error(w, "Failed to save your details", http.StatusInternalServerError)
+				return
+			}
+			wasComplete := user.OnboardingComplete()
+			user.Profile = profile
+			user.DateOfBirth = dateOfBirth
+			if !wasComplete && user.OnboardingComplete() {
+				log.Printf("User %d completed onboarding (club rules %s)", user.StravaID, clubRulesVersion)
+			}
+			next := user.NextOnboardingStep()
+			if next == "" {
+				http.Redirect(w, r, "/members", http.StatusSeeOther)
+				return
+			}
+			http.Redirect(w, r, "/welcome?step="+next, http.StatusSeeOther)
+			return
+		}
+	}
+
+	view := &OnboardingView{Step: step, Error: formError, RulesVersion: clubRulesVersion}
+	for _, s := range onboardingSteps {
+		view.Steps = append(view.Steps, OnboardingStep{Key: s.Key, Title: s.Title, Done: user.onboardingStepDone(s.Key), Current: s.Key == step})
+	}
+
+	data := TemplateData{
+		Location:     "Borrowash, Derbyshire",
+		CurrentYear:  time.Now().Year(),
+		IsLoggedIn:   true,
+		User:         user,
+		IsAdmin:      user.IsAdmin,
+		Onboarding:   view,
+		EmailEnabled: mailer != nil,
+		CSSVersion:   cssVersion,
+	}
+
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	err := tmpl.ExecuteTemplate(w, "onboarding.html", data)
+	if err != nil {
+		log.Printf("Error executing onboarding template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// onboardingStepFromForm validates a submitted step, returning the member's updated profile and
+// date of birth, or a problem to show them
+func onboardingStepFromForm(r *http.Request, step string, user *User, now time.Time) (MemberProfile, time.Time, string) {
+	profile := user.Profile
+	dateOfBirth := user.DateOfBirth
+
+	switch step {
+	case onboardingContact:
+		phone, ok := formPhone(r.FormValue("phone"))
+		if !ok {
+			return profile, dateOfBirth, invalidPhoneMessage
+		}
+		dob, err := time.Parse(onboardingDateLayout, r.FormValue("dateOfBirth"))
+		if err != nil || dob.After(now) || dob.Before(now.AddDate(-120, 0, 0)) {
+			return profile, dateOfBirth, "Please enter your date of birth."
+		}
+		profile.Phone = phone
+		dateOfBirth = dob
+
+	case onboardingEmergency:
+		name := strings.TrimSpace(r.FormValue("contactName"))
+		relationship := strings.TrimSpace(r.FormValue("contactRelationship"))
+		if name == "" || utf8.RuneCountInString(name) > maxProfileTextLength || utf8.RuneCountInString(relationship) > maxProfileTextLength {
+			return profile, dateOfBirth, "Please enter your emergency contact's name."
+		}
+		phone, ok := formPhone(r.FormValue("contactPhone"))
+		if !ok {
+			return profile, dateOfBirth, invalidPhoneMessage
+		}
+		if phone == profile.Phone {
+			return profile, dateOfBirth, "Your emergency contact needs a different phone number from yours."
+		}
+		profile.EmergencyContact = EmergencyContact{Name: name, Relationship: relationship, Phone: phone}
+
+	case onboardingMedical:
+		notes := strings.TrimSpace(r.FormValue("medicalNotes"))
+		if utf8.RuneCountInString(notes) > maxMedicalNotesLength {
+			return profile, dateOfBirth, fmt.Sprintf("Please keep your medical information under %d characters.", maxMedicalNotesLength)
+		}
+		profile.MedicalNotes = notes
+		profile.MedicalAnsweredAt = now
+
+	case onboardingPhotos:
+		switch r.FormValue("photoConsent") {
+		case "yes":
+			profile.PhotoConsent = true
+		case "no":
+			profile.PhotoConsent = false
+		default:
+			return profile, dateOfBirth, "Please choose whether you're happy to appear in club photos."
+		}
+		profile.PhotoAnsweredAt = now
+
+	case onboardingRules:
+		if r.FormValue("acceptRules") != "on" || r.FormValue("rulesVersion") != clubRulesVersion {
+			return profile, dateOfBirth, "Please read and accept the club rules to continue."
+		}
+		profile.RulesVersion = clubRulesVersion
+		profile.RulesAcceptedAt = now
+	}
+	return profile, dateOfBirth, ""
+}
+
+const invalidPhoneMessage = "Please enter a phone number, e.g. 07700 900123."
+
+// formPhone validates a phone number typed by a member
+func formPhone(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	return value, phonePattern.MatchString(value)
+}
+
+// onboardingStepTitle returns a step's title, or "" if there's no such step
+func onboardingStepTitle(step string) string {
+	for _, s := range onboardingSteps {
+		if s.Key == step {
+			return s.Title
+		}
+	}
+	return ""
+}
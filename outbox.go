@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OutboxMessage is an email waiting to be sent, or a record of one that was. Emails are rendered
+// when queued, so a retry sends exactly what was queued.
+type OutboxMessage struct {
+	ID            string    `bson:"_id,omitempty"`
+	Kind          string    `bson:"kind"`                // Which notification, e.g. "welcome"
+	DedupeKey     string    `bson:"dedupeKey,omitempty"` // Only one message is ever queued per key
+	UserID        int64     `bson:"userID"`              // Recipient's StravaID
+	To            string    `bson:"to"`
+	Subject       string    `bson:"subject"`
+	HTMLBody      string    `bson:"htmlBody"`
+	TextBody      string    `bson:"textBody"`
+	Status        string    `bson:"status"`
+	Attempts      int       `bson:"attempts"`
+	NextAttemptAt time.Time `bson:"nextAttemptAt"`
+	LastError     string    `bson:"lastError,omitempty"`
+	CreatedAt     time.Time `bson:"createdAt"`
+	SentAt        time.Time `bson:"sentAt,omitempty"`
+	DeletedAt     time.Time `bson:"deletedAt,omitempty"` // Set while the recipient's account is in the trash, see trash.go
+}
+
+const outboxCollection = "outbox" // MongoDB collection name
+
+// Outbox message statuses
+const (
+	outboxStatusPending = "pending"
+	outboxStatusSent    = "sent"
+	outboxStatusFailed  = "failed" // Gave up after outboxMaxAttempts
+)
+
+const (
+	outboxInterval     = time.Minute      // How often the outbox worker looks for messages to send
+	outboxBatchSize    = 50               // Messages sent per run, so a backlog drains gradually
+	outboxMaxAttempts  = 8                // About four hours of retries with outboxRetryBase
+	outboxRetryBase    = 2 * time.Minute  // Doubled after each failed attempt
+	outboxClaimTimeout = 10 * time.Minute // A claimed message is retried if its sender never reports back
+)
+
+// ensureOutboxIndexes makes dedupe keys unique, so a notification queued twice concurrently
+// is only sent once
+func ensureOutboxIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(outboxCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "dedupeKey", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"dedupeKey": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create outbox indexes: %w", err)
+	}
+	return nil
+}
+
+// EnqueueEmail adds a message to the outbox to be sent by the outbox worker. A message whose
+// DedupeKey was already queued is skipped; the result reports whether it was queued.
+func EnqueueEmail(ctx context.Context, msg *OutboxMessage) (bool, error) {
+	now := time.Now()
+	msg.Status = outboxStatusPending
+	msg.NextAttemptAt = now
+	msg.CreatedAt = now
+	collection := mongoDB.Collection(outboxCollection)
+
+	if msg.DedupeKey == "" {
+		res, err := collection.InsertOne(ctx, msg)
+		if err != nil {
+			return false, fmt.Errorf("failed to queue %s email: %w", msg.Kind, err)
+		}
+		if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+			msg.ID = oid.Hex()
+		}
+		return true, nil
+	}
+
+	opts := options.Update().SetUpsert(true)
+	res, err := collection.UpdateOne(ctx, bson.M{"dedupeKey": msg.DedupeKey}, bson.M{"$setOnInsert": msg}, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil // Queued concurrently
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to queue %s email: %w", msg.Kind, err)
+	}
+	if oid, ok := res.UpsertedID.(primitive.ObjectID); ok {
+		msg.ID = oid.Hex()
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+// GetUserEmails retrieves the messages queued for or sent to a member, oldest first
+func GetUserEmails(ctx context.Context, stravaID int64) ([]OutboxMessage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := mongoDB.Collection(outboxCollection).Find(ctx, bson.M{"userID": stravaID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding outbox messages for user %d: %w", stravaID, err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []OutboxMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("error decoding outbox messages for user %d: %w", stravaID, err)
+	}
+	return messages, nil
+}
+
+// SoftDeleteUserEmails moves a member's messages to the trash along with their account. They keep
+// the account's deletedAt, so they stay in the member's data export, aren't sent meanwhile, and
+// are restored or purged with the account.
+func SoftDeleteUserEmails(ctx context.Context, stravaID int64, now time.Time) error {
+	filter := bson.M{"userID": stravaID, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{"deletedAt": now}}
+	if _, err := mongoDB.Collection(outboxCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to delete outbox messages for user %d: %w", stravaID, err)
+	}
+	return nil
+}
+
+// claimOutboxMessage takes the next due message, pushing back its next attempt so other instances
+// of the site don't send it at the same time. It returns nil when nothing is due.
+func claimOutboxMessage(ctx context.Context, now time.Time) (*OutboxMessage, error) {
+	filter := bson.M{"status": outboxStatusPending, "nextAttemptAt": bson.M{"$lte": now}, "deletedAt": nil}
+	update := bson.M{
+		"$set": bson.M{"nextAttemptAt": now.Add(outboxClaimTimeout)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"nextAttemptAt": 1}).
+		SetReturnDocument(options.After)
+
+	var msg OutboxMessage
+	err := mongoDB.Collection(outboxCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox message: %w", err)
+	}
+	return &msg, nil
+}
+
+// markOutboxResult records whether sending a claimed message worked, scheduling a retry with
+// exponential backoff if it didn't
+func markOutboxResult(ctx context.Context, msg *OutboxMessage, sendErr error, now time.Time) error {
+	oid, err := parseObjectID("outbox message", msg.ID)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{}
+	switch {
+	case sendErr == nil:
+		set["status"] = outboxStatusSent
+		set["sentAt"] = now
+	case msg.Attempts >= outboxMaxAttempts:
+		set["status"] = outboxStatusFailed
+		set["lastError"] = sendErr.Error()
+	default:
+		set["lastError"] = sendErr.Error()
+		set["nextAttemptAt"] = now.Add(outboxRetryBase << (msg.Attempts - 1))
+	}
+
+	_, err = mongoDB.Collection(outboxCollection).UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("failed to update outbox message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// sendDueEmails sends up to outboxBatchSize due messages through the mailer
+func sendDueEmails(ctx context.Context) (sent, failed int) {
+	for i := 0; i < outboxBatchSize && ctx.Err() == nil; i++ {
+		now := time.Now()
+		msg, err := claimOutboxMessage(ctx, now)
+		if err != nil {
+			log.Printf("Error reading outbox: %v", err)
+			return
+		}
+		if msg == nil {
+			return
+		}
+
+		sendErr := mailer.Send(ctx, &EmailMessage{To: msg.To, Subject: msg.Subject, HTMLBody: msg.HTMLBody, TextBody: msg.TextBody})
+		if errors.Is(sendErr, context.Canceled) {
+			return // Shutting down; the claim times out and the message is retried
+		}
+		if sendErr != nil {
+			failed++
+			log.Printf("Error sending %s email to user %d (attempt %d): %v", msg.Kind, msg.UserID, msg.Attempts, sendErr)
+		} else {
+			sent++
+		}
+		if err := markOutboxResult(ctx, msg, sendErr, time.Now()); err != nil {
+			log.Printf("Error recording outbox result: %v", err)
+		}
+	}
+	return
+}
+
+// startOutboxWorker runs sendDueEmails periodically until ctx is cancelled
+func startOutboxWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(outboxInterval)
+		defer ticker.Stop()
+
+		for {
+			if sent, failed := sendDueEmails(ctx); sent > 0 || failed > 0 {
+				log.Printf("Outbox: %d emails sent, %d failed", sent, failed)
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Println("Outbox worker stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
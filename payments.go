@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// PaymentProvider takes online membership payments through a hosted checkout page
+type PaymentProvider interface {
+	// Name identifies the provider in logs and audit entries
+	Name() string
+	// CreateCheckout starts a checkout session and returns where to send the member to pay
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error)
+	// ParseWebhook verifies and decodes a payment notification sent by the provider.
+	// It returns a nil event for notifications that need no action.
+	ParseWebhook(r *http.Request) (*PaymentEvent, error)
+}
+
+// CheckoutRequest describes the membership being paid for
+type CheckoutRequest struct {
+	StravaID       int64
+	Email          string // Optional, pre-fills the provider's form
+	Season         int
+	MembershipType string
+	AmountPence    int
+	Description    string
+	SuccessURL     string
+	CancelURL      string
+}
+
+// CheckoutSession is a started checkout
+type CheckoutSession struct {
+	ID  string
+	URL string // Hosted payment page
+}
+
+// PaymentEvent is a verified, completed payment for a membership
+type PaymentEvent struct {
+	SessionID      string // Provider's checkout session ID, used to make processing idempotent
+	StravaID       int64
+	Season         int
+	MembershipType string
+	AmountPence    int
+}
+
+// errInvalidWebhook is returned when a payment notification fails verification
+var errInvalidWebhook = errors.New("invalid payment webhook")
+
+// paymentProvider is nil when online payments are disabled, in which case the members page
+// falls back to the Monzo link and admins record payments by hand.
+var paymentProvider PaymentProvider
+
+// newPaymentProviderFromEnv configures the provider selected by PAYMENT_PROVIDER
+func newPaymentProviderFromEnv() (PaymentProvider, error) {
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "":
+		return nil, nil
+	case "stripe":
+		secretKey := os.Getenv("STRIPE_SECRET_KEY")
+		webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+		if secretKey == "" || webhookSecret == "" {
+			return nil, errors.New("PAYMENT_PROVIDER=stripe requires STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+		}
+		return newStripeProvider(secretKey, webhookSecret, os.Getenv("STRIPE_API_URL")), nil
+	case "fake":
+		// Anyone could mark themselves paid through the fake provider, so it has to be asked for twice
+		if os.Getenv("ALLOW_FAKE_PAYMENTS") != "true" {
+			return nil, errors.New("PAYMENT_PROVIDER=fake lets anyone mark themselves paid; set ALLOW_FAKE_PAYMENTS=true to use it for development")
+		}
+		return newFakePaymentProvider(oauthCallbackURL), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q (expected stripe or fake)", os.Getenv("PAYMENT_PROVIDER"))
+	}
+}
+
+// handlePaymentEvent records the membership a completed payment was for. Providers may deliver
+// the same notification more than once, so a payment that was already recorded is ignored.
+func handlePaymentEvent(ctx context.Context, event *PaymentEvent) error {
+	m := newSeasonMembership(event.Season, event.MembershipType, event.AmountPence, 0)
+	m.PaymentRef = event.SessionID
+
+	added, err := AddMembershipForPayment(ctx, event.StravaID, m)
+	if err != nil {
+		return err
+	}
+	if !added {
+		log.Printf("Payment %s for user %d already recorded", event.SessionID, event.StravaID)
+		return nil
+	}
+
+	audit := &AuditEntry{
+		ActorID:   event.StravaID,
+		ActorName: paymentProvider.Name() + " checkout",
+		Action:    auditActionMembershipPaid,
+		TargetID:  strconv.FormatInt(event.StravaID, 10),
+		Details:   fmt.Sprintf("Season %d %s membership paid online (%s, ref %s)", event.Season, event.MembershipType, m.AmountPounds(), event.SessionID),
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for payment %s: %v", event.SessionID, err)
+	}
+	log.Printf("Membership for season %d paid online by user %d (ref %s)", event.Season, event.StravaID, event.SessionID)
+	return nil
+}
+
+// checkoutHandler starts a checkout for the chosen tier's current season membership and redirects to the provider
+func checkoutHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if paymentProvider == nil {
+		http.Error(w, "Online payments are not available", http.StatusNotFound)
+		return
+	}
+
+	season := seasonFor(time.Now())
+	if user.MembershipForSeason(season, time.Now()) != nil {
+		http.Redirect(w, r, "/members", http.StatusSeeOther) // Already paid, nothing to do
+		return
+	}
+
+	tier := tierByKey(r.FormValue("tier"))
+	if tier == nil {
+		http.Error(w, "Unknown membership tier", http.StatusBadRequest)
+		return
+	}
+	if eligible, reason := tier.EligibleFor(user, season); !eligible {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	req := CheckoutRequest{
+		StravaID:       user.StravaID,
+		Season:         season,
+		MembershipType: tier.Key,
+		AmountPence:    tier.PricePence,
+		Description:    fmt.Sprintf("SPCC %d %s Membership Subs", season, tier.Name),
+		Email:          user.Email,
+		SuccessURL:     oauthCallbackURL + "/payments/success",
+		CancelURL:      oauthCallbackURL + "/members",
+	}
+	session, err := paymentProvider.CreateCheckout(r.Context(), req)
+	if err != nil {
+		log.Printf("Error creating %s checkout for user %d: %v", paymentProvider.Name(), user.StravaID, err)
+		http.Error(w, "Failed to start payment, please try again later", http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("Started %s checkout %s for user %d", paymentProvider.Name(), session.ID, user.StravaID)
+	http.Redirect(w, r, session.URL, http.StatusSeeOther)
+}
+
+// paymentWebhookHandler receives payment notifications from the provider
+func paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if paymentProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	event, err := paymentProvider.ParseWebhook(r)
+	if err != nil {
+		log.Printf("Rejected %s payment webhook: %v", paymentProvider.Name(), err)
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return
+	}
+	if event == nil {
+		w.WriteHeader(http.StatusOK) // Event type we don't act on
+		return
+	}
+
+	// A failure here returns 500 so the provider retries the notification later
+	if err := handlePaymentEvent(r.Context(), event); err != nil {
+		log.Printf("Error handling payment %s: %v", event.SessionID, err)
+		http.Error(w, "Failed to record payment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// paymentSuccessHandler thanks the member after checkout. The membership is recorded by the
+// webhook, which may arrive a moment after the member is redirected here.
+func paymentSuccessHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		CSSVersion:  cssVersion,
+	}
+
+	err := tmpl.ExecuteTemplate(w, "payment_success.html", data)
+	if err != nil {
+		log.Printf("Error executing payment_success template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fakePaymentProvider stands in for a real provider during local development (PAYMENT_PROVIDER=fake).
+// Its checkout page lives on the site itself and completes the payment with a single click, and
+// its webhook is unsigned, so it's refused unless ALLOW_FAKE_PAYMENTS=true.
+type fakePaymentProvider struct {
+	baseURL  string
+	mu       sync.Mutex
+	sessions map[string]CheckoutRequest
+	nextID   int
+}
+
+func newFakePaymentProvider(baseURL string) *fakePaymentProvider {
+	return &fakePaymentProvider{baseURL: baseURL, sessions: map[string]CheckoutRequest{}}
+}
+
+func (p *fakePaymentProvider) Name() string { return "fake" }
+
+func (p *fakePaymentProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	id := fmt.Sprintf("fake_cs_%d_%d", time.Now().Unix(), p.nextID)
+	p.sessions[id] = req
+	return &CheckoutSession{ID: id, URL: p.baseURL + "/payments/fake/checkout?session=" + id}, nil
+}
+
+// ParseWebhook accepts {"sessionID": "..."} for a session this provider created,
+// so the webhook endpoint can be exercised locally with curl.
+func (p *fakePaymentProvider) ParseWebhook(r *http.Request) (*PaymentEvent, error) {
+	var body struct {
+		SessionID string `json:"sessionID"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidWebhook, err)
+	}
+	return p.complete(body.SessionID)
+}
+
+// complete turns a pending session into a payment event
+func (p *fakePaymentProvider) complete(sessionID string) (*PaymentEvent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown session %q", errInvalidWebhook, sessionID)
+	}
+	return &PaymentEvent{
+		SessionID:      sessionID,
+		StravaID:       req.StravaID,
+		Season:         req.Season,
+		MembershipType: req.MembershipType,
+		AmountPence:    req.AmountPence,
+	}, nil
+}
+
+// fakeCheckoutHandler renders the fake provider's payment page and completes the payment on POST
+func fakeCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := paymentProvider.(*fakePaymentProvider)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	sessionID := r.FormValue("session")
+	provider.mu.Lock()
+	req, found := provider.sessions[sessionID]
+	provider.mu.Unlock()
+	if !found {
+		http.Error(w, "Unknown checkout session", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		event, err := provider.complete(sessionID)
+		if err == nil {
+			err = handlePaymentEvent(r.Context(), event)
+		}
+		if err != nil {
+			log.Printf("Error completing fake payment %s: %v", sessionID, err)
+			http.Error(w, "Failed to complete payment", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, req.SuccessURL, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	data := struct {
+		CheckoutRequest
+		SessionID string
+		Amount    string
+	}{req, sessionID, fmt.Sprintf("%d.%02d", req.AmountPence/100, req.AmountPence%100)}
+	if err := fakeCheckoutPage.Execute(w, data); err != nil {
+		log.Printf("Error rendering fake checkout page: %v", err)
+	}
+}
+
+// fakeCheckoutPage is the fake provider's payment page. It's parsed here rather than kept with
+// the site's templates, as it stands in for a page on the provider's site.
+var fakeCheckoutPage = template.Must(template.New("fake_checkout").Parse(`<!DOCTYPE html><html><body style="font-family: sans-serif; text-align: center; padding: 3rem;">
+<h1>Fake Checkout</h1>
+<p>{{ .Description }} &mdash; £{{ .Amount }}</p>
+<form method="post"><input type="hidden" name="session" value="{{ .SessionID }}" /><button type="submit">Pay</button></form>
+<p><a href="{{ .CancelURL }}">Cancel</a></p>
+</body></html>`))
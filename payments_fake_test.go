@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFakePayments makes the fake provider the site's payment provider for the test
+func useFakePayments(t *testing.T) *fakePaymentProvider {
+	t.Helper()
+	provider := newFakePaymentProvider("http://localhost:8081")
+	previous := paymentProvider
+	paymentProvider = provider
+	t.Cleanup(func() { paymentProvider = previous })
+	return provider
+}
+
+func testCheckout(t *testing.T, provider *fakePaymentProvider, req CheckoutRequest) *CheckoutSession {
+	t.Helper()
+	session, err := provider.CreateCheckout(context.Background(), req)
+	if err != nil {
+		t.Fatalf("creating checkout: %v", err)
+	}
+	return session
+}
+
+func TestFakePaymentsNeedAllowing(t *testing.T) {
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+
+	t.Setenv("ALLOW_FAKE_PAYMENTS", "")
+	if provider, err := newPaymentProviderFromEnv(); err == nil || provider != nil {
+		t.Errorf("got provider %v, error %v; want the fake provider refused", provider, err)
+	}
+
+	t.Setenv("ALLOW_FAKE_PAYMENTS", "true")
+	provider, err := newPaymentProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider == nil || provider.Name() != "fake" {
+		t.Errorf("got provider %v, want the fake provider", provider)
+	}
+}
+
+func TestFakeWebhookCompletesKnownSessions(t *testing.T) {
+	provider := useFakePayments(t)
+	session := testCheckout(t, provider, CheckoutRequest{StravaID: 201, Season: 2025, MembershipType: "standard", AmountPence: 2500})
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(`{"sessionID": "`+session.ID+`"}`))
+	event, err := provider.ParseWebhook(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PaymentEvent{SessionID: session.ID, StravaID: 201, Season: 2025, MembershipType: "standard", AmountPence: 2500}
+	if *event != want {
+		t.Errorf("got event %+v, want %+v", *event, want)
+	}
+
+	for _, body := range []string{`{"sessionID": "fake_cs_unknown"}`, `not json`} {
+		req := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(body))
+		if _, err := provider.ParseWebhook(req); !errors.Is(err, errInvalidWebhook) {
+			t.Errorf("webhook %s: got error %v, want errInvalidWebhook", body, err)
+		}
+	}
+}
+
+func TestFakeCheckoutPageEscapesRequest(t *testing.T) {
+	provider := useFakePayments(t)
+	session := testCheckout(t, provider, CheckoutRequest{
+		StravaID:    202,
+		AmountPence: 2500,
+		Description: `<script>alert("paid")</script>`,
+		CancelURL:   "javascript:alert(1)",
+	})
+
+	w := httptest.NewRecorder()
+	fakeCheckoutHandler(w, httptest.NewRequest(http.MethodGet, "/payments/fake/checkout?session="+session.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	page := w.Body.String()
+	if strings.Contains(page, "<script>") || strings.Contains(page, "javascript:") {
+		t.Errorf("checkout page doesn't escape the request:\n%s", page)
+	}
+	if !strings.Contains(page, "£25.00") {
+		t.Errorf("checkout page doesn't show the amount:\n%s", page)
+	}
+}
+
+func TestFakeCheckoutRecordsPaymentOnce(t *testing.T) {
+	ctx := useTestMongo(t)
+	user := testLogin(t, ctx, 203)
+	provider := useFakePayments(t)
+	season := seasonFor(time.Now())
+	session := testCheckout(t, provider, CheckoutRequest{
+		StravaID:       user.StravaID,
+		Season:         season,
+		MembershipType: "standard",
+		AmountPence:    2500,
+		SuccessURL:     "/payments/success",
+	})
+
+	form := url.Values{"session": {session.ID}}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/payments/fake/checkout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	fakeCheckoutHandler(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("got status %d, want a redirect to the success page", w.Code)
+	}
+
+	// The provider delivering the same notification again doesn't record a second membership
+	w = httptest.NewRecorder()
+	paymentWebhookHandler(w, httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(`{"sessionID": "`+session.ID+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("replayed webhook: got status %d, want 200", w.Code)
+	}
+
+	got, err := GetUserByID(ctx, user.StravaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsPaidMember {
+		t.Error("not a paid member after paying")
+	}
+	if len(got.Memberships) != 1 || got.Memberships[0].PaymentRef != session.ID {
+		t.Errorf("got memberships %+v, want one for payment %s", got.Memberships, session.ID)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"unicode/utf8"
+)
+
+// directoryMembers applies each member's privacy settings to the list shown to viewer.
+// Admins and the membership secretary see everyone unredacted so they can manage memberships, and members always see their own card as-is.
+func directoryMembers(members []User, viewer *User) []User {
+	if viewer != nil && viewer.Can(permViewHiddenMembers) {
+		return members
+	}
+
+	visible := make([]User, 0, len(members))
+	for _, member := range members {
+		if viewer != nil && member.StravaID == viewer.StravaID {
+			visible = append(visible, member)
+			continue
+		}
+		if member.Privacy.HideFromDirectory {
+			continue
+		}
+		member.LastName = member.displayLastName()
+		if member.Privacy.HidePhoto {
+			member.ProfilePicURL = ""
+		}
+		visible = append(visible, member)
+	}
+	return visible
+}
+
+// displayLastName is the member's last name as other members see it, only the initial if they chose
+func (u *User) displayLastName() string {
+	if u.Privacy.NameDisplay == nameDisplayInitial && u.LastName != "" {
+		initial, _ := utf8.DecodeRuneInString(u.LastName)
+		return string(initial) + "."
+	}
+	return u.LastName
+}
+
+// DisplayName is the member's name as other members see it, wherever it's shown: on routes, rides
+// and stats as well as in the directory
+func (u *User) DisplayName() string {
+	return fmt.Sprintf("%s %s", u.FirstName, u.displayLastName())
+}
+
+// privacySettingsHandler saves the logged-in member's directory privacy settings
+// and re-renders the members grid so they can see the effect.
+func privacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Error(w, "Unauthorized: Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings := PrivacySettings{
+		NameDisplay:       r.FormValue("nameDisplay"),
+		HidePhoto:         r.FormValue("hidePhoto") == "on",
+		HideFromDirectory: r.FormValue("hideFromDirectory") == "on",
+	}
+	if settings.NameDisplay != nameDisplayFull && settings.NameDisplay != nameDisplayInitial {
+		http.Error(w, "Invalid name display option", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if err := UpdatePrivacySettings(ctx, user.StravaID, settings); err != nil {
+		log.Printf("Error updating privacy settings for user %d: %v", user.StravaID, err)
+		http.Error(w, "Failed to save privacy settings", http.StatusInternalServerError)
+		return
+	}
+	user.Privacy = settings
+
+	members, err := GetAllUsers(ctx)
+	if err != nil {
+		log.Printf("Error fetching all members after privacy update: %v", err)
+		http.Error(w, "Failed to load updated members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		User:    user,
+		IsAdmin: user.IsAdmin,
+		Members: directoryMembers(members, user),
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "members_grid_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing members_grid_fragment template: %v", err)
+		http.Error(w, "Failed to render updated list", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // The container image has no time zone database
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ride is a club ride members can sign up for
+type Ride struct {
+	ID           string     `bson:"_id,omitempty"`
+	Title        string     `bson:"title"`
+	StartsAt     time.Time  `bson:"startsAt"`
+	MeetingPoint string     `bson:"meetingPoint"`
+	RouteID      string     `bson:"routeID,omitempty"`
+	RouteName    string     `bson:"routeName,omitempty"` // Name when the ride was scheduled, kept if the route is deleted
+	LeaderID     int64      `bson:"leaderID"`            // StravaID of the ride leader who scheduled it
+	LeaderName   string     `bson:"leaderName"`
+	RSVPs        []RideRSVP `bson:"rsvps"`
+	CreatedAt    time.Time  `bson:"createdAt"`
+}
+
+// RideRSVP is a member who has said they're coming on a ride
+type RideRSVP struct {
+	UserID int64     `bson:"userID"`
+	Name   string    `bson:"name"`
+	At     time.Time `bson:"at"`
+}
+
+const ridesCollection = "rides" // MongoDB collection name
+
+const (
+	maxRideTitleLength  = 100
+	rideStartsAtLayout  = "2006-01-02T15:04" // As sent by a datetime-local input
+	rideSheetTimeLayout = "Mon 2 Jan 2006, 15:04"
+)
+
+// clubLocation is the time zone rides are scheduled and shown in, whatever the server's zone
+var clubLocation = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		log.Fatalf("Failed to load club time zone: %v", err)
+	}
+	return loc
+}()
+
+// LocalStartsAt is when the ride starts in the club's time zone
+func (r *Ride) LocalStartsAt() time.Time {
+	return r.StartsAt.In(clubLocation)
+}
+
+// IsGoing reports whether a member has signed up for the ride
+func (r *Ride) IsGoing(stravaID int64) bool {
+	for _, rsvp := range r.RSVPs {
+		if rsvp.UserID == stravaID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanViewSheet reports whether a user may see the ride sheet, with riders' emergency contacts.
+// Only the ride's leader, while they're still a ride leader, and admins can.
+func (r *Ride) CanViewSheet(u *User) bool {
+	return u != nil && ((u.StravaID == r.LeaderID && u.Can(permLeadRides)) || u.IsAdmin)
+}
+
+// CreateRide adds a new ride to MongoDB
+func CreateRide(ctx context.Context, ride *Ride) error {
+	ride.CreatedAt = time.Now()
+	if ride.RSVPs == nil {
+		ride.RSVPs = []RideRSVP{}
+	}
+	res, err := mongoDB.Collection(ridesCollection).InsertOne(ctx, ride)
+	if err != nil {
+		return fmt.Errorf("failed to create ride document: %w", err)
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		ride.ID = oid.Hex()
+	}
+	return nil
+}
+
+// GetRideByID retrieves a single ride by its MongoDB document ID
+func GetRideByID(ctx context.Context, rideID string) (*Ride, error) {
+	objID, err := parseObjectID("ride", rideID)
+	if err != nil {
+		return nil, err
+	}
+	var ride Ride
+	err = mongoDB.Collection(ridesCollection).FindOne(ctx, bson.M{"_id": objID}).Decode(&ride)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("ride", rideID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ride document: %w", err)
+	}
+	ride.ID = objID.Hex()
+	return &ride, nil
+}
+
+// GetUpcomingRides retrieves rides starting after the given time, soonest first
+func GetUpcomingRides(ctx context.Context, after time.Time) ([]Ride, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startsAt", Value: 1}})
+	return findRides(ctx, bson.M{"startsAt": bson.M{"$gt": after}}, opts)
+}
+
+// GetUserRSVPRides retrieves every ride a member has signed up for, most recent first
+func GetUserRSVPRides(ctx context.Context, stravaID int64) ([]Ride, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startsAt", Value: -1}})
+	return findRides(ctx, bson.M{"rsvps.userID": stravaID}, opts)
+}
+
+// findRides runs a query against the rides collection
+func findRides(ctx context.Context, filter interface{}, opts *options.FindOptions) ([]Ride, error) {
+	cursor, err := mongoDB.Collection(ridesCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding rides: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rides []Ride
+	for cursor.Next(ctx) {
+		var ride Ride
+		if err := cursor.Decode(&ride); err != nil {
+			return nil, fmt.Errorf("error decoding ride: %w", err)
+		}
+		if oid, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			ride.ID = oid.Hex()
+		}
+		rides = append(rides, ride)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return rides, nil
+}
+
+// SetRideRSVP signs a member up for a ride, or takes them off it. Signing up twice is a no-op.
+func SetRideRSVP(ctx context.Context, rideID string, rsvp RideRSVP, going bool) error {
+	objID, err := parseObjectID("ride", rideID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objID}
+	var update bson.M
+	if going {
+		filter["rsvps.userID"] = bson.M{"$ne": rsvp.UserID}
+		update = bson.M{"$push": bson.M{"rsvps": rsvp}}
+	} else {
+		update = bson.M{"$pull": bson.M{"rsvps": bson.M{"userID": rsvp.UserID}}}
+	}
+	if _, err := mongoDB.Collection(ridesCollection).UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to update RSVP for user %d on ride %s: %w", rsvp.UserID, rideID, err)
+	}
+	return nil
+}
+
+// DeleteUserRSVPs takes a member off every ride when their account is deleted
+func DeleteUserRSVPs(ctx context.Context, stravaID int64) error {
+	filter := bson.M{"rsvps.userID": stravaID}
+	update := bson.M{"$pull": bson.M{"rsvps": bson.M{"userID": stravaID}}}
+	if _, err := mongoDB.Collection(ridesCollection).UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to delete RSVPs for user %d: %w", stravaID, err)
+	}
+	return nil
+}
+
+// RideSheet is the printable sheet a ride leader takes on a ride
+type RideSheet struct {
+	Ride         *Ride
+	Route        *Route // Nil if the ride has no route or it has since been deleted
+	Riders       []RideSheetRider
+	ShowMedical  bool // The viewer may see medical notes, see permViewMedicalNotes
+	GeneratedAt  time.Time
+	GeneratedFor string
+}
+
+// RideSheetRider is a rider on the ride sheet
+type RideSheetRider struct {
+	Name             string
+	Phone            string
+	EmergencyContact EmergencyContact
+	MedicalNotes     string
+}
+
+// buildRideSheet lists the riders signed up for a ride, in the order they signed up
+func buildRideSheet(ride *Ride, route *Route, members []User, viewer *User, now time.Time) *RideSheet {
+	byID := make(map[int64]*User, len(members))
+	for i := range members {
+		byID[members[i].StravaID] = &members[i]
+	}
+
+	sheet := &RideSheet{
+		Ride:         ride,
+		Route:        route,
+		ShowMedical:  viewer.Can(permViewMedicalNotes),
+		GeneratedAt:  now.In(clubLocation),
+		GeneratedFor: fmt.Sprintf("%s %s", viewer.FirstName, viewer.LastName),
+	}
+	for _, rsvp := range ride.RSVPs {
+		rider := RideSheetRider{Name: rsvp.Name}
+		if member, ok := byID[rsvp.UserID]; ok {
+			rider.Name = fmt.Sprintf("%s %s", member.FirstName, member.LastName)
+			rider.Phone = member.Profile.Phone
+			rider.EmergencyContact = member.Profile.EmergencyContact
+			if sheet.ShowMedical {
+				rider.MedicalNotes = member.Profile.MedicalNotes
+			}
+		}
+		sheet.Riders = append(sheet.Riders, rider)
+	}
+	return sheet
+}
+
+// ridesHandler shows upcoming club rides, and a form to schedule one for ride leaders
+func ridesHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	rides, err := GetUpcomingRides(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error fetching upcoming rides: %v", err)
+		http.Error(w, "Failed to load rides", http.StatusInternalServerError)
+		return
+	}
+
+	var routes []Route
+	if user.Can(permLeadRides) {
+		if routes, err = GetApprovedRoutes(ctx); err != nil {
+			log.Printf("Error fetching routes for the ride form: %v", err)
+		}
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Rides:       rides,
+		Routes:      routes,
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "rides.html", data)
+	if err != nil {
+		log.Printf("Error executing rides template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// createRideHandler schedules a ride led by the logged-in ride leader
+func createRideHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permLeadRides) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	title := strings.TrimSpace(r.FormValue("title"))
+	meetingPoint := strings.TrimSpace(r.FormValue("meetingPoint"))
+	startsAt, startsErr := time.ParseInLocation(rideStartsAtLayout, r.FormValue("startsAt"), clubLocation)
+
+	notice := ""
+	switch {
+	case title == "" || utf8.RuneCountInString(title) > maxRideTitleLength:
+		notice = "Please give the ride a title."
+	case startsErr != nil || !startsAt.After(now):
+		notice = "Please choose a start time in the future."
+	case meetingPoint == "" || utf8.RuneCountInString(meetingPoint) > maxProfileTextLength:
+		notice = "Please enter where riders should meet."
+	}
+
+	ride := &Ride{
+		Title:        title,
+		StartsAt:     startsAt,
+		MeetingPoint: meetingPoint,
+		LeaderID:     user.StravaID,
+		LeaderName:   user.DisplayName(),
+	}
+	if routeID := r.FormValue("routeID"); notice == "" && routeID != "" {
+		route, err := GetRouteByID(ctx, routeID)
+		if err != nil {
+			log.Printf("Error getting route %s for new ride: %v", routeID, err)
+			notice = "That route is no longer in the library. Please choose another."
+		} else {
+			ride.RouteID = route.ID
+			ride.RouteName = route.Name
+		}
+	}
+
+	if notice == "" {
+		if err := CreateRide(ctx, ride); err != nil {
+			log.Printf("Error creating ride: %v", err)
+			http.Error(w, "Failed to schedule ride", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Ride %s (%s) scheduled by user %d", ride.ID, ride.Title, user.StravaID)
+		notice = fmt.Sprintf("%s is scheduled for %s.", ride.Title, ride.LocalStartsAt().Format(rideSheetTimeLayout))
+	}
+
+	renderRidesList(w, r, user, notice)
+}
+
+// rideRSVPHandler signs the logged-in member up for a ride, or takes them off it
+func rideRSVPHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Error(w, "Unauthorized: Not logged in", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	rideID := r.FormValue("rideID")
+	ride, err := GetRideByID(ctx, rideID)
+	if err != nil {
+		log.Printf("Error getting ride %s for RSVP: %v", rideID, err)
+		writeStoreError(w, r, "Ride", err)
+		return
+	}
+	if !ride.StartsAt.After(time.Now()) {
+		renderRidesList(w, r, user, "That ride has already started.")
+		return
+	}
+
+	going := r.FormValue("going") == "yes"
+	rsvp := RideRSVP{UserID: user.StravaID, Name: user.DisplayName(), At: time.Now()}
+	if err := SetRideRSVP(ctx, ride.ID, rsvp, going); err != nil {
+		log.Printf("Error updating RSVP: %v", err)
+		http.Error(w, "Failed to update your RSVP", http.StatusInternalServerError)
+		return
+	}
+
+	renderRidesList(w, r, user, "")
+}
+
+// renderRidesList re-renders the upcoming rides for an HTMX swap
+func renderRidesList(w http.ResponseWriter, r *http.Request, user *User, notice string) {
+	rides, err := GetUpcomingRides(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Error fetching upcoming rides: %v", err)
+		http.Error(w, "Failed to load updated rides list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		IsLoggedIn: true,
+		User:       user,
+		IsAdmin:    user.IsAdmin,
+		Rides:      rides,
+		Notice:     notice,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "rides_list_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing rides_list_fragment template: %v", err)
+		http.Error(w, "Failed to render updated rides list", http.StatusInternalServerError)
+	}
+}
+
+// rideSheetHandler shows the printable ride sheet: the riders signed up, their emergency
+// contacts and the route. Every view is recorded in the audit log, as the sheet holds
+// members' personal details.
+func rideSheetHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	rideID := r.FormValue("id")
+	ride, err := GetRideByID(ctx, rideID)
+	if err != nil {
+		log.Printf("Error getting ride %s for ride sheet: %v", rideID, err)
+		writeStoreError(w, r, "Ride", err)
+		return
+	}
+	if !ride.CanViewSheet(user) {
+		http.Error(w, "Forbidden: Only the ride leader and admins can see the ride sheet.", http.StatusForbidden)
+		return
+	}
+
+	riderIDs := make([]int64, 0, len(ride.RSVPs))
+	for _, rsvp := range ride.RSVPs {
+		riderIDs = append(riderIDs, rsvp.UserID)
+	}
+	members, err := GetUsersByIDs(ctx, riderIDs)
+	if err != nil {
+		log.Printf("Error fetching riders for ride %s: %v", ride.ID, err)
+		http.Error(w, "Failed to load riders", http.StatusInternalServerError)
+		return
+	}
+
+	var route *Route
+	if ride.RouteID != "" {
+		if route, err = GetRouteByID(ctx, ride.RouteID); err != nil {
+			log.Printf("Error getting route %s for ride sheet: %v", ride.RouteID, err)
+			route = nil
+		}
+	}
+
+	sheet := buildRideSheet(ride, route, members, user, time.Now())
+	medical := "hidden"
+	if sheet.ShowMedical {
+		medical = "shown"
+	}
+
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionRideSheetViewed,
+		TargetID:  ride.ID,
+		Details:   fmt.Sprintf("%s (%s): %d riders, medical notes %s", ride.Title, ride.LocalStartsAt().Format(rideSheetTimeLayout), len(sheet.Riders), medical),
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for ride sheet %s: %v", ride.ID, err)
+	}
+
+	data := TemplateData{
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		RideSheet:   sheet,
+		CSSVersion:  cssVersion,
+	}
+
+	w.Header().Set("Cache-Control", "no-store") // Personal details shouldn't linger in caches
+	err = tmpl.ExecuteTemplate(w, "ride_sheet.html", data)
+	if err != nil {
+		log.Printf("Error executing ride_sheet template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCanViewSheet(t *testing.T) {
+	const leaderID = 100
+	ride := &Ride{LeaderID: leaderID}
+
+	tests := []struct {
+		name     string
+		stravaID int64
+		roles    []string
+		want     bool
+	}{
+		{"leader", leaderID, []string{roleRideLeader}, true},
+		{"leader who's no longer a ride leader", leaderID, nil, false},
+		{"leader with another role", leaderID, []string{roleRouteCurator}, false},
+		{"another ride leader", 200, []string{roleRideLeader}, false},
+		{"member who signed up", 300, nil, false},
+		{"membership secretary", 400, []string{roleMembershipSecretary}, false},
+		{"admin", 500, []string{roleAdmin}, true},
+		{"leader who's now an admin", leaderID, []string{roleAdmin}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &User{StravaID: tt.stravaID, Roles: tt.roles}
+			deriveAdminStatus(u)
+			if got := ride.CanViewSheet(u); got != tt.want {
+				t.Errorf("CanViewSheet = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if ride.CanViewSheet(nil) {
+		t.Error("CanViewSheet(nil) = true, want false")
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Roles a member can hold. Every logged-in user is implicitly a member; the other roles are
+// granted by an admin and stored in User.Roles.
+const (
+	roleMember              = "member"
+	roleRideLeader          = "ride_leader"
+	roleRouteCurator        = "route_curator"
+	roleMembershipSecretary = "membership_secretary"
+	roleAdmin               = "admin"
+)
+
+// Permissions checked by handlers and templates, see User.Can
+const (
+	permManageMemberships = "memberships.manage" // Mark members paid, record renewals
+	permManageTiers       = "tiers.manage"       // Edit membership tier pricing and benefits
+	permExportMemberData  = "members.export"     // Export a member's data for a subject access request
+	permViewHiddenMembers = "members.view_all"   // See the directory without members' privacy settings applied
+	permManageRoutes      = "routes.manage"      // Edit or delete any member's routes
+	permManageRoles       = "roles.manage"       // Grant and revoke roles
+	permViewAudit         = "audit.view"         // Read and export the audit log
+	permViewStats         = "stats.view"         // See the admin dashboard
+	permViewMedicalNotes  = "members.medical"    // See members' medical notes; deliberately not given to admins
+	permLeadRides         = "rides.lead"         // Schedule club rides
+	permManageTrash       = "trash.manage"       // Restore or permanently delete deleted routes and accounts
+)
+
+// Role describes a role for the admin roles page
+type Role struct {
+	Key         string
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// allRoles is the permission matrix. Permissions a tier grants (e.g. submitting routes) are
+// capabilities of the membership, not roles; see HasCapability.
+var allRoles = []Role{
+	{roleMember, "Member", "Everyone who has logged in. Features depend on their membership tier.", nil},
+	{roleRideLeader, "Ride Leader", "Schedules and leads club rides, and sees riders' medical notes.", []string{permLeadRides, permViewMedicalNotes}},
+	{roleRouteCurator, "Route Curator", "Looks after the route library.", []string{permManageRoutes}},
+	{roleMembershipSecretary, "Membership Secretary", "Manages memberships, renewals and subject access requests.",
+		[]string{permManageMemberships, permManageTiers, permExportMemberData, permViewHiddenMembers, permViewStats}},
+	{roleAdmin, "Admin", "Full access, including granting roles and the audit log.",
+		[]string{permManageMemberships, permManageTiers, permExportMemberData, permViewHiddenMembers, permManageRoutes, permManageRoles, permViewAudit, permViewStats, permLeadRides, permManageTrash}},
+}
+
+// grantableRoles are the roles an admin can grant; member is implicit
+func grantableRoles() []Role {
+	return allRoles[1:]
+}
+
+// roleByKey returns the role with the given key, or nil if there is none
+func roleByKey(key string) *Role {
+	for i := range allRoles {
+		if allRoles[i].Key == key {
+			return &allRoles[i]
+		}
+	}
+	return nil
+}
+
+// HasRole reports whether the user holds a role
+func (u *User) HasRole(role string) bool {
+	if role == roleMember {
+		return true
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the user's roles grants a permission
+func (u *User) Can(permission string) bool {
+	for _, key := range u.Roles {
+		role := roleByKey(key)
+		if role == nil {
+			continue
+		}
+		for _, p := range role.Permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleNames lists the user's granted roles for display
+func (u *User) RoleNames() []string {
+	var names []string
+	for _, key := range u.Roles {
+		if role := roleByKey(key); role != nil {
+			names = append(names, role.Name)
+		}
+	}
+	return names
+}
+
+// deriveAdminStatus sets IsAdmin from the member's roles. The stored isAdmin flag predates roles
+// and is migrated to the admin role at startup, see migrateLegacyAdmins.
+func deriveAdminStatus(u *User) {
+	u.IsAdmin = u.HasRole(roleAdmin)
+}
+
+// SetUserRoles replaces a member's granted roles, keeping the legacy isAdmin flag in step
+func SetUserRoles(ctx context.Context, stravaID int64, roles []string) error {
+	if roles == nil {
+		roles = []string{}
+	}
+	collection := mongoDB.Collection(usersCollection)
+	update := bson.M{"$set": bson.M{"roles": roles, "isAdmin": contains(roles, roleAdmin)}}
+	result, err := collection.UpdateOne(ctx, excludeDeleted(bson.M{"stravaID": stravaID}), update)
+	if err != nil {
+		return fmt.Errorf("failed to update roles for user %d: %w", stravaID, err)
+	}
+	if result.MatchedCount == 0 {
+		return notFoundError("user", stravaID)
+	}
+	return nil
+}
+
+// GrantRoleToUsers adds a role to every listed user in a single update, returning how many
+// didn't already have it
+func GrantRoleToUsers(ctx context.Context, stravaIDs []int64, role string) (int64, error) {
+	update := bson.M{"$addToSet": bson.M{"roles": role}}
+	if role == roleAdmin {
+		update["$set"] = bson.M{"isAdmin": true}
+	}
+	filter := bson.M{"stravaID": bson.M{"$in": stravaIDs}, "roles": bson.M{"$ne": role}}
+	res, err := mongoDB.Collection(usersCollection).UpdateMany(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to grant role %s: %w", role, err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// migrateLegacyAdmins gives the admin role to users who were made admins by setting isAdmin by hand
+func migrateLegacyAdmins(ctx context.Context) (int64, error) {
+	collection := mongoDB.Collection(usersCollection)
+	filter := bson.M{"isAdmin": true, "roles": bson.M{"$ne": roleAdmin}}
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$addToSet": bson.M{"roles": roleAdmin}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate legacy admins: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// bootstrapAdminIDs are the Strava IDs listed in ADMIN_STRAVA_IDS. They are always admins, so the
+// first admin can be set up without editing the database and can't be locked out.
+var bootstrapAdminIDs = parseStravaIDList(os.Getenv("ADMIN_STRAVA_IDS"))
+
+// parseStravaIDList parses a comma-separated list of Strava IDs, skipping invalid entries
+func parseStravaIDList(list string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid Strava ID %q in ADMIN_STRAVA_IDS", field)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// isBootstrapAdmin reports whether a Strava ID is listed in ADMIN_STRAVA_IDS
+func isBootstrapAdmin(stravaID int64) bool {
+	for _, id := range bootstrapAdminIDs {
+		if id == stravaID {
+			return true
+		}
+	}
+	return false
+}
+
+// bootstrapAdmins grants the admin role to already-registered users listed in ADMIN_STRAVA_IDS.
+// Listed users who haven't logged in yet are granted it on their first login.
+func bootstrapAdmins(ctx context.Context) (int64, error) {
+	if len(bootstrapAdminIDs) == 0 {
+		return 0, nil
+	}
+	collection := mongoDB.Collection(usersCollection)
+	filter := bson.M{"stravaID": bson.M{"$in": bootstrapAdminIDs}, "roles": bson.M{"$ne": roleAdmin}}
+	update := bson.M{"$addToSet": bson.M{"roles": roleAdmin}, "$set": bson.M{"isAdmin": true}}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bootstrap admins: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// contains reports whether a string slice contains a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// adminRolesHandler lists members with their roles so admins can grant and revoke them
+func adminRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageRoles) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	members, err := GetAllUsers(r.Context())
+	if err != nil {
+		log.Printf("Error fetching members for roles page: %v", err)
+		http.Error(w, "Failed to load members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Members:     members,
+		Roles:       allRoles,
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_roles.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_roles template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminUpdateRolesHandler saves the roles ticked for a member and re-renders the roles table
+func adminUpdateRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageRoles) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(r.FormValue("userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var roles []string
+	for _, role := range grantableRoles() {
+		if contains(r.Form["roles"], role.Key) {
+			roles = append(roles, role.Key)
+		}
+	}
+	if !contains(roles, roleAdmin) && (targetUserID == user.StravaID || isBootstrapAdmin(targetUserID)) {
+		http.Error(w, "You can't remove the admin role from yourself or an ADMIN_STRAVA_IDS admin", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	targetUser, err := GetUserByID(ctx, targetUserID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", targetUserID, err)
+		writeStoreError(w, r, "User", err)
+		return
+	}
+	previous := targetUser.Roles
+
+	if err := SetUserRoles(ctx, targetUserID, roles); err != nil {
+		log.Printf("Error updating roles for user %d: %v", targetUserID, err)
+		http.Error(w, "Failed to update roles", http.StatusInternalServerError)
+		return
+	}
+	targetUser.Roles = roles
+
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionRolesChanged,
+		TargetID:  strconv.FormatInt(targetUserID, 10),
+		Details:   fmt.Sprintf("%s %s", targetUser.FirstName, targetUser.LastName),
+		Before:    map[string]string{"roles": roleList(previous)},
+		After:     map[string]string{"roles": roleList(roles)},
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for roles of user %d: %v", targetUserID, err)
+	}
+	log.Printf("Roles for user %d set to %v by %s", targetUserID, roles, user.FirstName)
+
+	members, err := GetAllUsers(ctx)
+	if err != nil {
+		log.Printf("Error fetching members after roles update: %v", err)
+		http.Error(w, "Failed to load updated members list", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		User:    user,
+		IsAdmin: user.IsAdmin,
+		Members: members,
+		Roles:   allRoles,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "roles_table_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing roles_table_fragment template: %v", err)
+		http.Error(w, "Failed to render updated roles", http.StatusInternalServerError)
+	}
+}
+
+// roleList formats granted roles for an audit entry
+func roleList(roles []string) string {
+	if len(roles) == 0 {
+		return roleMember
+	}
+	return strings.Join(roles, ",")
+}
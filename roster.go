@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const rosterMaxUploadBytes = 2 << 20 // 2MB is thousands of rows
+
+// RosterPreview is the dry run of a roster import: what applying it would change
+type RosterPreview struct {
+	Season    int
+	Changes   []RosterChange
+	Unchanged int
+	Problems  []RosterProblem
+}
+
+// RosterChange is a member whose paid status the import would change
+type RosterChange struct {
+	Row      int
+	Member   User
+	MarkPaid bool
+	Tier     MembershipTier // Tier recorded when marking paid
+}
+
+// RosterProblem is a row the import can't apply
+type RosterProblem struct {
+	Row    int
+	Name   string
+	Reason string
+}
+
+// rosterRow is a parsed roster CSV row
+type rosterRow struct {
+	line      int
+	stravaID  int64
+	firstName string
+	lastName  string
+	paid      bool
+	tier      string
+}
+
+// Name is how the row is shown in the preview
+func (r rosterRow) Name() string {
+	return strings.TrimSpace(r.firstName + " " + r.lastName)
+}
+
+// parseRosterCSV reads the roster columns, matched by header name: strava_id, first_name,
+// last_name (or a single name column), paid and an optional tier.
+func parseRosterCSV(input io.Reader) ([]rosterRow, []RosterProblem, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1 // Spreadsheets often drop trailing empty cells
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) // Excel adds a BOM
+		columns[strings.ReplaceAll(name, " ", "_")] = i
+	}
+	if _, ok := columns["paid"]; !ok {
+		return nil, nil, errors.New("the file needs a paid column")
+	}
+	_, hasID := columns["strava_id"]
+	_, hasName := columns["name"]
+	_, hasFirst := columns["first_name"]
+	if !hasID && !hasName && !hasFirst {
+		return nil, nil, errors.New("the file needs a strava_id, name or first_name column")
+	}
+
+	var rows []rosterRow
+	var problems []RosterProblem
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read row %d: %w", line, err)
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := rosterRow{line: line, firstName: field("first_name"), lastName: field("last_name"), tier: field("tier")}
+		if row.firstName == "" && row.lastName == "" {
+			row.firstName, row.lastName, _ = strings.Cut(field("name"), " ")
+		}
+		if id := field("strava_id"); id != "" {
+			if row.stravaID, err = strconv.ParseInt(id, 10, 64); err != nil {
+				problems = append(problems, RosterProblem{Row: line, Name: row.Name(), Reason: fmt.Sprintf("Invalid Strava ID %q", id)})
+				continue
+			}
+		}
+		if row.stravaID == 0 && row.Name() == "" {
+			continue // Blank line
+		}
+		paid, ok := parseRosterPaid(field("paid"))
+		if !ok {
+			problems = append(problems, RosterProblem{Row: line, Name: row.Name(), Reason: fmt.Sprintf("Paid must be yes or no, not %q", field("paid"))})
+			continue
+		}
+		row.paid = paid
+		rows = append(rows, row)
+	}
+	return rows, problems, nil
+}
+
+// parseRosterPaid reads the spreadsheet's paid column
+func parseRosterPaid(value string) (paid, ok bool) {
+	switch strings.ToLower(value) {
+	case "yes", "y", "true", "1", "paid":
+		return true, true
+	case "no", "n", "false", "0", "unpaid", "":
+		return false, true
+	}
+	return false, false
+}
+
+// buildRosterPreview matches rows to members by Strava ID, or by full name when the ID is missing,
+// and works out which paid statuses would change for the season.
+func buildRosterPreview(rows []rosterRow, problems []RosterProblem, members []User, season int, now time.Time) *RosterPreview {
+	preview := &RosterPreview{Season: season, Problems: problems}
+
+	byID := make(map[int64]*User, len(members))
+	byName := make(map[string][]*User, len(members))
+	for i := range members {
+		member := &members[i]
+		byID[member.StravaID] = member
+		name := strings.ToLower(member.FirstName + " " + member.LastName)
+		byName[name] = append(byName[name], member)
+	}
+
+	seen := make(map[int64]int) // StravaID to the row that matched it
+	for _, row := range rows {
+		var member *User
+		if row.stravaID != 0 {
+			member = byID[row.stravaID]
+			if member == nil {
+				preview.Problems = append(preview.Problems, RosterProblem{Row: row.line, Name: row.Name(), Reason: fmt.Sprintf("No member with Strava ID %d", row.stravaID)})
+				continue
+			}
+		} else {
+			matches := byName[strings.ToLower(row.Name())]
+			if len(matches) == 0 {
+				preview.Problems = append(preview.Problems, RosterProblem{Row: row.line, Name: row.Name(), Reason: "No member with this name; add their Strava ID"})
+				continue
+			}
+			if len(matches) > 1 {
+				preview.Problems = append(preview.Problems, RosterProblem{Row: row.line, Name: row.Name(), Reason: "Several members have this name; add their Strava ID"})
+				continue
+			}
+			member = matches[0]
+		}
+		if first, ok := seen[member.StravaID]; ok {
+			name := fmt.Sprintf("%s %s", member.FirstName, member.LastName)
+			preview.Problems = append(preview.Problems, RosterProblem{Row: row.line, Name: name, Reason: fmt.Sprintf("Same member as row %d", first)})
+			continue
+		}
+		seen[member.StravaID] = row.line
+
+		tier := defaultTier()
+		if row.tier != "" {
+			tier = rosterTier(row.tier)
+		}
+		if tier == nil {
+			preview.Problems = append(preview.Problems, RosterProblem{Row: row.line, Name: row.Name(), Reason: fmt.Sprintf("Unknown tier %q", row.tier)})
+			continue
+		}
+
+		if row.paid == isPaidForSeason(member, season, now) {
+			preview.Unchanged++
+			continue
+		}
+		preview.Changes = append(preview.Changes, RosterChange{Row: row.line, Member: *member, MarkPaid: row.paid, Tier: *tier})
+	}
+	sort.Slice(preview.Problems, func(i, j int) bool { return preview.Problems[i].Row < preview.Problems[j].Row })
+	return preview
+}
+
+// rosterTier finds a tier by key or name, ignoring case
+func rosterTier(value string) *MembershipTier {
+	for _, tier := range allTiers() {
+		if strings.EqualFold(tier.Key, value) || strings.EqualFold(tier.Name, value) {
+			return &tier
+		}
+	}
+	return nil
+}
+
+// isPaidForSeason reports whether the member has a membership for the season that hasn't been ended
+func isPaidForSeason(u *User, season int, now time.Time) bool {
+	return u.MembershipForSeason(season, now) != nil
+}
+
+// writeRosterCSV writes the membership roster
+func writeRosterCSV(w io.Writer, members []User, season int) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"first_name", "last_name", "strava_id", "paid", "tier", "last_login"})
+	for _, member := range members {
+		paid, tier := "no", ""
+		if m := member.MembershipForSeason(season, time.Now()); m != nil {
+			paid, tier = "yes", m.TierName()
+		}
+		lastLogin := ""
+		if !member.LastLogin.IsZero() {
+			lastLogin = member.LastLogin.Format(auditDateLayout)
+		}
+		cw.Write([]string{
+			csvSafe(member.FirstName),
+			csvSafe(member.LastName),
+			strconv.FormatInt(member.StravaID, 10),
+			paid,
+			tier,
+			lastLogin,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// adminRosterHandler shows the roster import and export page
+func adminRosterHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	renderRosterPage(w, user, nil, "")
+}
+
+// adminRosterExportHandler downloads the roster for a season as CSV
+func adminRosterExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	season := seasonFor(time.Now())
+	if s, err := strconv.Atoi(r.FormValue("season")); err == nil {
+		season = s
+	}
+
+	members, err := GetAllUsers(r.Context())
+	if err != nil {
+		log.Printf("Error fetching members for roster export: %v", err)
+		http.Error(w, "Failed to load members list", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("southpeakscc-roster-%d-%s.csv", season, time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if err := writeRosterCSV(w, members, season); err != nil {
+		log.Printf("Error writing roster CSV: %v", err)
+	}
+}
+
+// adminRosterImportHandler parses an uploaded roster and shows a dry run of the changes.
+// Nothing is saved until the admin confirms, see adminRosterApplyHandler.
+func adminRosterImportHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, rosterMaxUploadBytes)
+	season, err := strconv.Atoi(r.FormValue("season"))
+	if err != nil {
+		http.Error(w, "Invalid season", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("roster")
+	if err != nil {
+		http.Error(w, "Choose a CSV file to import", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rows, problems, err := parseRosterCSV(file)
+	if err != nil {
+		renderRosterPage(w, user, nil, "Couldn't read the roster: "+err.Error())
+		return
+	}
+
+	members, err := GetAllUsers(r.Context())
+	if err != nil {
+		log.Printf("Error fetching members for roster import: %v", err)
+		http.Error(w, "Failed to load members list", http.StatusInternalServerError)
+		return
+	}
+
+	renderRosterPage(w, user, buildRosterPreview(rows, problems, members, season, time.Now()), "")
+}
+
+// adminRosterApplyHandler applies the paid-status changes confirmed on the preview. The preview
+// posts the changes back rather than the file, so what's applied is exactly what was shown.
+func adminRosterApplyHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageMemberships) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	season, err := strconv.Atoi(r.FormValue("season"))
+	if err != nil {
+		http.Error(w, "Invalid season", http.StatusBadRequest)
+		return
+	}
+
+	// markPaid values are "<stravaID>:<tier key>"; markUnpaid values are StravaIDs
+	paidByTier := make(map[string][]int64)
+	var unpaid []int64
+	for _, value := range r.Form["markPaid"] {
+		idStr, tierKey, _ := strings.Cut(value, ":")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || tierByKey(tierKey) == nil {
+			http.Error(w, "Invalid change", http.StatusBadRequest)
+			return
+		}
+		paidByTier[tierKey] = append(paidByTier[tierKey], id)
+	}
+	for _, value := range r.Form["markUnpaid"] {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid change", http.StatusBadRequest)
+			return
+		}
+		unpaid = append(unpaid, id)
+	}
+
+	ctx := r.Context()
+	after := map[string]string{"season": strconv.Itoa(season)}
+	var added, ended int64
+	for tierKey, ids := range paidByTier {
+		tier := tierByKey(tierKey)
+		n, err := AddSeasonMemberships(ctx, ids, newSeasonMembership(season, tier.Key, tier.PricePence, user.StravaID))
+		if err != nil {
+			log.Printf("Error applying roster import (mark paid): %v", err)
+			http.Error(w, "Failed to apply roster", http.StatusInternalServerError)
+			return
+		}
+		added += n
+		after[tier.Key] = joinStravaIDs(ids)
+	}
+	if len(unpaid) > 0 {
+		if ended, err = EndCurrentMembershipsForUsers(ctx, unpaid); err != nil {
+			log.Printf("Error applying roster import (mark unpaid): %v", err)
+			http.Error(w, "Failed to apply roster", http.StatusInternalServerError)
+			return
+		}
+		after["unpaid"] = joinStravaIDs(unpaid)
+	}
+
+	notice := fmt.Sprintf("Roster applied for %d: %d members marked paid, %d marked unpaid.", season, added, ended)
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionRosterImported,
+		TargetID:  strconv.Itoa(season),
+		Details:   notice,
+		After:     after,
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for roster import: %v", err)
+	}
+	log.Printf("%s (by %s)", notice, user.FirstName)
+
+	renderRosterPage(w, user, nil, notice)
+}
+
+// joinStravaIDs formats IDs for an audit entry
+func joinStravaIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// renderRosterPage renders the roster page with an optional import preview or notice
+func renderRosterPage(w http.ResponseWriter, user *User, preview *RosterPreview, notice string) {
+	data := TemplateData{
+		Location:      "Borrowash, Derbyshire",
+		CurrentYear:   time.Now().Year(),
+		IsLoggedIn:    true,
+		User:          user,
+		IsAdmin:       user.IsAdmin,
+		RosterPreview: preview,
+		Notice:        notice,
+		CSSVersion:    cssVersion,
+	}
+
+	err := tmpl.ExecuteTemplate(w, "admin_roster.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_roster template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxReviewNoteLength = 1000
+
+// Curator decisions on a pending route, and the status each one leaves the route in
+var routeReviewDecisions = map[string]string{
+	"approve":         routeStatusApproved,
+	"reject":          routeStatusRejected,
+	"request_changes": routeStatusChangesRequested,
+}
+
+// adminRouteQueueHandler shows route curators the routes waiting for approval
+func adminRouteQueueHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageRoutes) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	routes, err := GetPendingRoutes(r.Context())
+	if err != nil {
+		log.Printf("Error fetching pending routes: %v", err)
+		http.Error(w, "Failed to load the route queue", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Routes:      routes,
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_route_queue.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_route_queue template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminReviewRouteHandler approves or rejects a pending route, or asks its submitter for changes,
+// and lets the submitter know
+func adminReviewRouteHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageRoutes) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, ok := routeReviewDecisions[r.FormValue("decision")]
+	if !ok {
+		http.Error(w, "Invalid decision", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	routeID := r.FormValue("routeID")
+	route, err := GetRouteByID(ctx, routeID)
+	if err != nil {
+		log.Printf("Error getting route %s for review: %v", routeID, err)
+		writeStoreError(w, r, "Route", err)
+		return
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	notice := ""
+	switch {
+	case status != routeStatusApproved && note == "":
+		notice = fmt.Sprintf("Please tell the submitter why %s can't be approved yet.", route.Name)
+	case utf8.RuneCountInString(note) > maxReviewNoteLength:
+		notice = fmt.Sprintf("Please keep your note under %d characters.", maxReviewNoteLength)
+	}
+	if notice != "" {
+		renderRouteQueue(w, r, user, notice)
+		return
+	}
+
+	reviewed, err := ReviewRoute(ctx, route, status, note, user, time.Now())
+	if err != nil {
+		log.Printf("Error reviewing route %s: %v", route.ID, err)
+		http.Error(w, "Failed to review route", http.StatusInternalServerError)
+		return
+	}
+	if !reviewed {
+		renderRouteQueue(w, r, user, fmt.Sprintf("%s has already been reviewed.", route.Name))
+		return
+	}
+
+	before := route.Status
+	route.Status = status
+	route.ReviewNote = note
+	log.Printf("Route %s (%s) reviewed by user %d: %s", route.ID, route.Name, user.StravaID, status)
+
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionRouteReviewed,
+		TargetID:  route.ID,
+		Details:   route.Name,
+		Before:    map[string]string{"status": before},
+		After:     map[string]string{"status": status, "note": note},
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for review of route %s: %v", route.ID, err)
+	}
+
+	// Routes handed over to the club have nobody to tell
+	if submitterID := route.SubmittedByUserID; submitterID != clubRouteOwnerID {
+		if submitter, err := GetUserByID(ctx, submitterID); err != nil {
+			log.Printf("Error loading submitter %d of route %s: %v", submitterID, route.ID, err)
+		} else if err := sendRouteReviewedEmail(ctx, submitter, route); err != nil {
+			log.Printf("Error queueing route review email for user %d: %v", submitterID, err)
+		}
+	}
+
+	renderRouteQueue(w, r, user, fmt.Sprintf("%s: %s.", route.Name, strings.ToLower(route.StatusLabel())))
+}
+
+// renderRouteQueue re-renders the pending routes for an HTMX swap
+func renderRouteQueue(w http.ResponseWriter, r *http.Request, user *User, notice string) {
+	routes, err := GetPendingRoutes(r.Context())
+	if err != nil {
+		log.Printf("Error fetching pending routes: %v", err)
+		http.Error(w, "Failed to load the route queue", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		User:    user,
+		IsAdmin: user.IsAdmin,
+		Routes:  routes,
+		Notice:  notice,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "route_queue_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing route_queue_fragment template: %v", err)
+		http.Error(w, "Failed to render the route queue", http.StatusInternalServerError)
+	}
+}
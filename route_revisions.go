@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RouteRevision is a previous version of a route, kept whenever the route is changed
+type RouteRevision struct {
+	ID            string    `bson:"_id,omitempty"`
+	RouteID       string    `bson:"routeID"`
+	Route         Route     `bson:"route"` // The route as it was before the change
+	Change        string    `bson:"change"`
+	ChangedByID   int64     `bson:"changedByID"`
+	ChangedByName string    `bson:"changedByName"`
+	ChangedAt     time.Time `bson:"changedAt"`
+}
+
+const routeRevisionsCollection = "route_revisions" // MongoDB collection name
+
+// Changes that replace a route's current version
+const (
+	routeChangeReclassified = "reclassified"
+	routeChangeResubmitted  = "resubmitted"
+	routeChangeReviewed     = "reviewed"
+	routeChangeRestored     = "restored"
+)
+
+// errRouteChanged is returned when saving a route someone else changed after it was loaded
+var errRouteChanged = errors.New("route was changed by someone else")
+
+// recordRouteRevision keeps a copy of a route's previous version
+func recordRouteRevision(ctx context.Context, previous *Route, editor *User, change string, now time.Time) error {
+	revision := RouteRevision{
+		RouteID:       previous.ID,
+		Route:         *previous,
+		Change:        change,
+		ChangedByID:   editor.StravaID,
+		ChangedByName: editor.DisplayName(),
+		ChangedAt:     now,
+	}
+	revision.Route.ID = "" // The snapshot isn't a route document in its own right
+	if _, err := mongoDB.Collection(routeRevisionsCollection).InsertOne(ctx, revision); err != nil {
+		return fmt.Errorf("failed to record revision of route %s: %w", previous.ID, err)
+	}
+	return nil
+}
+
+// GetRouteRevisions retrieves a route's previous versions, newest first
+func GetRouteRevisions(ctx context.Context, routeID string) ([]RouteRevision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "changedAt", Value: -1}})
+	cursor, err := mongoDB.Collection(routeRevisionsCollection).Find(ctx, bson.M{"routeID": routeID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding revisions of route %s: %w", routeID, err)
+	}
+	defer cursor.Close(ctx)
+
+	var revisions []RouteRevision
+	for cursor.Next(ctx) {
+		var revision RouteRevision
+		if err := cursor.Decode(&revision); err != nil {
+			return nil, fmt.Errorf("error decoding route revision: %w", err)
+		}
+		if oid, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			revision.ID = oid.Hex()
+		}
+		revision.Route.ID = routeID
+		revisions = append(revisions, revision)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return revisions, nil
+}
+
+// GetRouteRevisionByID retrieves a single revision by its MongoDB document ID
+func GetRouteRevisionByID(ctx context.Context, revisionID string) (*RouteRevision, error) {
+	objID, err := parseObjectID("revision", revisionID)
+	if err != nil {
+		return nil, err
+	}
+	var revision RouteRevision
+	err = mongoDB.Collection(routeRevisionsCollection).FindOne(ctx, bson.M{"_id": objID}).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("revision", revisionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get route revision: %w", err)
+	}
+	revision.ID = objID.Hex()
+	revision.Route.ID = revision.RouteID
+	return &revision, nil
+}
+
+// DeleteRouteRevisions deletes a route's history when the route is purged from the trash
+func DeleteRouteRevisions(ctx context.Context, routeID string) error {
+	if _, err := mongoDB.Collection(routeRevisionsCollection).DeleteMany(ctx, bson.M{"routeID": routeID}); err != nil {
+		return fmt.Errorf("failed to delete revisions of route %s: %w", routeID, err)
+	}
+	return nil
+}
+
+// ReassignUserRouteRevisionsToClub hands a deleted member's route history over to the club along
+// with their routes, so restoring an old version doesn't give a route back to them
+func ReassignUserRouteRevisionsToClub(ctx context.Context, userID int64) error {
+	update := bson.M{"$set": bson.M{"route.submittedByUserID": clubRouteOwnerID}}
+	_, err := mongoDB.Collection(routeRevisionsCollection).UpdateMany(ctx, bson.M{"route.submittedByUserID": userID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to reassign route revisions for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// RouteDetail is a route with its history, for the route detail page
+type RouteDetail struct {
+	Route      *Route
+	Revisions  []RouteRevision
+	CanRestore bool // Only admins can restore an old version
+}
+
+// canViewRoute reports whether a user may see a route. Routes outside the route library are only
+// visible to their submitter and route curators.
+func canViewRoute(u *User, route *Route) bool {
+	if u.Can(permManageRoutes) || route.IsSubmittedBy(u) {
+		return true
+	}
+	return route.IsApproved() && u.HasCapability(capViewRoutes)
+}
+
+// routeDetailHandler shows a route with the history of its changes
+func routeDetailHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn {
+		http.Redirect(w, r, "/login/strava", http.StatusFound)
+		return
+	}
+	if !requireOnboarding(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	routeID := r.FormValue("id")
+	route, err := GetRouteByID(ctx, routeID)
+	if err != nil {
+		log.Printf("Error getting route %s: %v", routeID, err)
+		writeStoreError(w, r, "Route", err)
+		return
+	}
+	if !canViewRoute(user, route) {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := GetRouteRevisions(ctx, route.ID)
+	if err != nil {
+		log.Printf("Error fetching revisions of route %s: %v", route.ID, err)
+		http.Error(w, "Failed to load route history", http.StatusInternalServerError)
+		return
+	}
+
+	renderRouteDetail(w, user, &RouteDetail{Route: route, Revisions: revisions, CanRestore: user.IsAdmin}, "")
+}
+
+// restoredRoute is the route as it was in the revision, keeping the current route's identity and,
+// unless restoreStatus is set, its review status
+func restoredRoute(current *Route, revision *RouteRevision, restoreStatus bool) Route {
+	restored := revision.Route
+	restored.ID = current.ID
+	restored.CreatedAt = current.CreatedAt
+	if !restoreStatus {
+		restored.Status = current.Status
+		restored.ReviewNote = current.ReviewNote
+		restored.ReviewedByID = current.ReviewedByID
+		restored.ReviewedByName = current.ReviewedByName
+		restored.ReviewedAt = current.ReviewedAt
+	}
+	return restored
+}
+
+// restoreRouteRevisionHandler lets an admin put a previous version of a route back. The version
+// it replaces is kept as a revision, so a restore can itself be undone. The route keeps its
+// current review status unless the admin asks to restore that too, so restoring a version from
+// before the route was approved doesn't take it out of the route library.
+func restoreRouteRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.IsAdmin {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	revisionID := r.FormValue("revisionID")
+	revision, err := GetRouteRevisionByID(ctx, revisionID)
+	if err != nil {
+		log.Printf("Error getting route revision %s: %v", revisionID, err)
+		writeStoreError(w, r, "Revision", err)
+		return
+	}
+	current, err := GetRouteByID(ctx, revision.RouteID)
+	if err != nil {
+		log.Printf("Error getting route %s to restore: %v", revision.RouteID, err)
+		writeStoreError(w, r, "Route", err)
+		return
+	}
+
+	restored := restoredRoute(current, revision, r.FormValue("restoreStatus") == "yes")
+	notice := fmt.Sprintf("Restored the version from before the change on %s.", revision.ChangedAt.Format("Jan 2, 2006 15:04"))
+	err = UpdateRoute(ctx, current, &restored, user, routeChangeRestored)
+	if errors.Is(err, errRouteChanged) {
+		notice = "The route was changed while you were looking at it. Please check its history and try again."
+	} else if errors.Is(err, ErrDuplicate) {
+		notice = fmt.Sprintf("Another route is now called %s, so this version can't be restored.", restored.Name)
+	} else if err != nil {
+		log.Printf("Error restoring route %s: %v", current.ID, err)
+		http.Error(w, "Failed to restore route", http.StatusInternalServerError)
+		return
+	} else {
+		log.Printf("Route %s restored to revision %s by user %d", current.ID, revision.ID, user.StravaID)
+
+		audit := &AuditEntry{
+			ActorID:   user.StravaID,
+			ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			Action:    auditActionRouteRestored,
+			TargetID:  current.ID,
+			Details:   fmt.Sprintf("%s, revision %s", restored.Name, revision.ID),
+			Before:    map[string]string{"name": current.Name, "classify": current.Classify, "status": current.Status},
+			After:     map[string]string{"name": restored.Name, "classify": restored.Classify, "status": restored.Status},
+		}
+		if err := RecordAudit(ctx, audit); err != nil {
+			log.Printf("Error recording audit entry for restore of route %s: %v", current.ID, err)
+		}
+	}
+
+	route, err := GetRouteByID(ctx, current.ID)
+	if err != nil {
+		log.Printf("Error reloading route %s after restore: %v", current.ID, err)
+		http.Error(w, "Failed to load route", http.StatusInternalServerError)
+		return
+	}
+	revisions, err := GetRouteRevisions(ctx, route.ID)
+	if err != nil {
+		log.Printf("Error fetching revisions of route %s: %v", route.ID, err)
+		http.Error(w, "Failed to load route history", http.StatusInternalServerError)
+		return
+	}
+
+	renderRouteDetail(w, user, &RouteDetail{Route: route, Revisions: revisions, CanRestore: true}, notice)
+}
+
+// renderRouteDetail renders the route detail page
+func renderRouteDetail(w http.ResponseWriter, user *User, detail *RouteDetail, notice string) {
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		RouteDetail: detail,
+		Notice:      notice,
+		CSSVersion:  cssVersion,
+	}
+
+	err := tmpl.ExecuteTemplate(w, "route_detail.html", data)
+	if err != nil {
+		log.Printf("Error executing route_detail template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanViewRoute(t *testing.T) {
+	now := time.Now()
+	const submitterID = 100
+
+	// member builds a user with the given roles, paid up unless paid is false. The membership's
+	// type isn't a known tier, so it grants the legacy capabilities, including viewing routes.
+	member := func(stravaID int64, paid bool, roles ...string) *User {
+		u := &User{StravaID: stravaID, Roles: roles}
+		if paid {
+			u.Memberships = []Membership{{Season: now.Year(), Type: "legacy", StartsAt: now.Add(-time.Hour), ExpiresAt: now.Add(24 * time.Hour)}}
+		}
+		deriveAdminStatus(u)
+		return u
+	}
+
+	tests := []struct {
+		name   string
+		user   *User
+		status string
+		want   bool
+	}{
+		{"paid member, approved route", member(200, true), routeStatusApproved, true},
+		{"paid member, route from before approval", member(200, true), "", true},
+		{"unpaid member, approved route", member(200, false), routeStatusApproved, false},
+		{"paid member, pending route", member(200, true), routeStatusPending, false},
+		{"paid member, rejected route", member(200, true), routeStatusRejected, false},
+		{"paid member, route awaiting changes", member(200, true), routeStatusChangesRequested, false},
+		{"submitter, pending route", member(submitterID, false), routeStatusPending, true},
+		{"submitter, rejected route", member(submitterID, false), routeStatusRejected, true},
+		{"curator, pending route", member(300, false, roleRouteCurator), routeStatusPending, true},
+		{"admin, rejected route", member(400, false, roleAdmin), routeStatusRejected, true},
+		{"ride leader, pending route", member(500, true, roleRideLeader), routeStatusPending, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &Route{SubmittedByUserID: submitterID, Status: tt.status}
+			if got := canViewRoute(tt.user, route); got != tt.want {
+				t.Errorf("canViewRoute = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoredRoute(t *testing.T) {
+	created := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	approved := time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)
+	current := &Route{
+		ID:             "current",
+		Name:           "Peak Loop",
+		Classify:       "hilly",
+		CreatedAt:      created,
+		Status:         routeStatusApproved,
+		ReviewedByID:   300,
+		ReviewedByName: "Curator",
+		ReviewedAt:     approved,
+	}
+	revision := &RouteRevision{
+		RouteID: "current",
+		Route: Route{
+			ID:         "stale",
+			Name:       "Peak Loop (old)",
+			Classify:   "flat",
+			CreatedAt:  created.Add(time.Hour),
+			Status:     routeStatusChangesRequested,
+			ReviewNote: "Please fix the start",
+		},
+	}
+
+	t.Run("keeps the current status", func(t *testing.T) {
+		got := restoredRoute(current, revision, false)
+		if got.Name != "Peak Loop (old)" || got.Classify != "flat" {
+			t.Errorf("restored %q (%s), want the revision's name and classification", got.Name, got.Classify)
+		}
+		if got.ID != current.ID || !got.CreatedAt.Equal(created) {
+			t.Errorf("restored ID %q created %v, want the current route's %q created %v", got.ID, got.CreatedAt, current.ID, created)
+		}
+		if got.Status != routeStatusApproved || got.ReviewNote != "" || got.ReviewedByID != 300 || !got.ReviewedAt.Equal(approved) {
+			t.Errorf("restored review %s %q by %d at %v, want the current approval", got.Status, got.ReviewNote, got.ReviewedByID, got.ReviewedAt)
+		}
+	})
+
+	t.Run("restores the status when asked", func(t *testing.T) {
+		got := restoredRoute(current, revision, true)
+		if got.ID != current.ID || !got.CreatedAt.Equal(created) {
+			t.Errorf("restored ID %q created %v, want the current route's %q created %v", got.ID, got.CreatedAt, current.ID, created)
+		}
+		if got.Status != routeStatusChangesRequested || got.ReviewNote != "Please fix the start" || got.ReviewedByID != 0 {
+			t.Errorf("restored review %s %q by %d, want the revision's", got.Status, got.ReviewNote, got.ReviewedByID)
+		}
+	})
+
+	if revision.Route.ID != "stale" {
+		t.Errorf("restoredRoute changed the revision's route ID to %q", revision.Route.ID)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Similar route detection. A route is compared with the club's routes by Strava route ID, by the
+// shape of its track and by name, and the submitter is warned about any matches before it's saved.
+const (
+	similarRouteMaxDistance   = 250.0 // Metres; tracks that never stray further apart than this are the same ride
+	polylineSimplifyTolerance = 30.0  // Metres; Douglas-Peucker tolerance, well inside similarRouteMaxDistance
+	earthRadiusMetres         = 6371000.0
+)
+
+// SimilarRoute is an existing route that looks like the one being submitted
+type SimilarRoute struct {
+	Route  Route
+	Reason string
+}
+
+// SimilarRouteWarning asks the submitter to confirm a route that looks like one the club already has.
+// It carries the submission so it can be confirmed without choosing the route again.
+type SimilarRouteWarning struct {
+	Name          string
+	StravaRouteID int64
+	Classify      string
+	Matches       []SimilarRoute
+}
+
+// latLng is a point on a decoded polyline
+type latLng struct {
+	Lat, Lng float64
+}
+
+// trackPoint is a point projected onto a flat plane, in metres
+type trackPoint struct {
+	X, Y float64
+}
+
+// RouteBounds is the bounding box of a route's track in degrees, stored so routes near a new one
+// can be found with an indexed query rather than decoding every route's polyline
+type RouteBounds struct {
+	MinLat float64 `bson:"minLat"`
+	MinLng float64 `bson:"minLng"`
+	MaxLat float64 `bson:"maxLat"`
+	MaxLng float64 `bson:"maxLng"`
+}
+
+// polylineBounds returns the bounding box of an encoded polyline, or nil if it's empty or invalid
+func polylineBounds(polyline string) *RouteBounds {
+	points, err := decodePolyline(polyline)
+	if err != nil || len(points) == 0 {
+		return nil
+	}
+	b := &RouteBounds{MinLat: points[0].Lat, MinLng: points[0].Lng, MaxLat: points[0].Lat, MaxLng: points[0].Lng}
+	for _, p := range points[1:] {
+		b.MinLat, b.MinLng = math.Min(b.MinLat, p.Lat), math.Min(b.MinLng, p.Lng)
+		b.MaxLat, b.MaxLng = math.Max(b.MaxLat, p.Lat), math.Max(b.MaxLng, p.Lng)
+	}
+	return b
+}
+
+// nearbyBoundsFilter matches routes whose bounding box could belong to a track within maxDistance
+// metres of one with bounds b. Each edge of such a box lies within maxDistance of the same edge
+// of b, plus the simplification tolerance, as trackDistance compares simplified tracks.
+func nearbyBoundsFilter(b *RouteBounds, maxDistance float64) bson.M {
+	margin := maxDistance + 2*polylineSimplifyTolerance
+	latMargin := margin / earthRadiusMetres * 180 / math.Pi
+	lngMargin := latMargin / math.Cos((b.MinLat+b.MaxLat)/2*math.Pi/180)
+	within := func(value, margin float64) bson.M {
+		return bson.M{"$gte": value - margin, "$lte": value + margin}
+	}
+	return bson.M{
+		"bounds.minLat": within(b.MinLat, latMargin),
+		"bounds.maxLat": within(b.MaxLat, latMargin),
+		"bounds.minLng": within(b.MinLng, lngMargin),
+		"bounds.maxLng": within(b.MaxLng, lngMargin),
+	}
+}
+
+// findSimilarRoutes lists the club's routes that look like candidate. Rejected routes are ignored,
+// as they'll never be in the route library. Each check is an indexed query, so only routes with
+// the same Strava ID, a nearby track or the same name are loaded.
+func findSimilarRoutes(ctx context.Context, candidate *Route) ([]SimilarRoute, error) {
+	notRejected := bson.M{"status": bson.M{"$ne": routeStatusRejected}}
+	newestFirst := bson.D{{Key: "submittedAt", Value: -1}}
+
+	var similar []SimilarRoute
+	seen := map[string]bool{candidate.ID: candidate.ID != ""}
+	add := func(route Route, reason string) {
+		if !seen[route.ID] {
+			seen[route.ID] = true
+			similar = append(similar, SimilarRoute{Route: route, Reason: reason})
+		}
+	}
+
+	if candidate.StravaRouteID != 0 {
+		filter := bson.M{"$and": bson.A{notRejected, bson.M{"stravaRouteID": candidate.StravaRouteID}}}
+		routes, err := findRoutes(ctx, filter, newestFirst)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range routes {
+			add(route, "It's the same Strava route")
+		}
+	}
+
+	candidateTrack, err := decodePolyline(candidate.Polyline)
+	if err != nil {
+		// Strava sent a polyline we can't read; the other checks still apply
+		log.Printf("Skipping track comparison for %s: invalid polyline: %v", candidate.Name, err)
+	}
+	if bounds := polylineBounds(candidate.Polyline); bounds != nil {
+		filter := bson.M{"$and": bson.A{notRejected, nearbyBoundsFilter(bounds, similarRouteMaxDistance)}}
+		routes, err := findRoutes(ctx, filter, newestFirst)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range routes {
+			track, err := decodePolyline(route.Polyline)
+			if err != nil {
+				// A bad polyline on one route shouldn't stop anyone submitting
+				continue
+			}
+			if distance, ok := trackDistance(candidateTrack, track, similarRouteMaxDistance); ok {
+				add(route, fmt.Sprintf("It follows the same roads, never more than %.0f m apart", distance))
+			}
+		}
+	}
+
+	if name := strings.TrimSpace(candidate.Name); name != "" {
+		filter := bson.M{"$and": bson.A{notRejected, bson.M{"name": name}}}
+		routes, err := findRoutes(ctx, filter, newestFirst, options.Find().SetCollation(routeNameCollation))
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range routes {
+			add(route, "It has the same name")
+		}
+	}
+	return similar, nil
+}
+
+// backfillRouteBounds gives routes from before bounds were stored the bounding box of their track
+func backfillRouteBounds(ctx context.Context) (int64, error) {
+	coll := mongoDB.Collection(routesCollection)
+	filter := bson.M{"polyline": bson.M{"$nin": bson.A{"", nil}}, "bounds": bson.M{"$exists": false}}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"polyline": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find routes without bounds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var updated int64
+	for cursor.Next(ctx) {
+		var route struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Polyline string             `bson:"polyline"`
+		}
+		if err := cursor.Decode(&route); err != nil {
+			return updated, fmt.Errorf("failed to decode route: %w", err)
+		}
+		bounds := polylineBounds(route.Polyline)
+		if bounds == nil {
+			continue
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": route.ID}, bson.M{"$set": bson.M{"bounds": bounds}}); err != nil {
+			return updated, fmt.Errorf("failed to set bounds for route %s: %w", route.ID.Hex(), err)
+		}
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, fmt.Errorf("cursor error: %w", err)
+	}
+	return updated, nil
+}
+
+// decodePolyline decodes a polyline in Google's encoded polyline format, as used by Strava's
+// summary_polyline. An empty string decodes to no points.
+func decodePolyline(encoded string) ([]latLng, error) {
+	var points []latLng
+	var lat, lng int64
+	for i := 0; i < len(encoded); {
+		var deltas [2]int64
+		for j := range deltas {
+			var result int64
+			var shift uint
+			for {
+				if i >= len(encoded) {
+					return nil, errors.New("polyline ends mid-point")
+				}
+				b := int64(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, fmt.Errorf("invalid polyline character %q", encoded[i-1])
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+				if shift > 60 {
+					return nil, errors.New("polyline value too long")
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^(result >> 1)
+			} else {
+				deltas[j] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		points = append(points, latLng{Lat: float64(lat) / 1e5, Lng: float64(lng) / 1e5})
+	}
+	return points, nil
+}
+
+// trackDistance compares two tracks, reporting the Hausdorff distance between them in metres: how
+// far the furthest point of either track is from the other. Tracks are simplified first, as
+// summary polylines can have thousands of points. It reports false without finishing when the
+// tracks are obviously further apart than maxDistance.
+func trackDistance(a, b []latLng, maxDistance float64) (float64, bool) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, false
+	}
+	// Project both tracks onto the same plane, centred on the first track's start. Routes are
+	// short enough for an equirectangular projection to be accurate to a few metres.
+	origin := a[0]
+	pa := simplifyTrack(projectTrack(a, origin), polylineSimplifyTolerance)
+	pb := simplifyTrack(projectTrack(b, origin), polylineSimplifyTolerance)
+
+	if boundsGap(pa, pb) > maxDistance {
+		return 0, false
+	}
+	ab := directedHausdorff(pa, pb, maxDistance)
+	if ab > maxDistance {
+		return 0, false
+	}
+	ba := directedHausdorff(pb, pa, maxDistance)
+	if ba > maxDistance {
+		return 0, false
+	}
+	return math.Max(ab, ba), true
+}
+
+// projectTrack converts points to metres east and north of origin
+func projectTrack(points []latLng, origin latLng) []trackPoint {
+	cosLat := math.Cos(origin.Lat * math.Pi / 180)
+	projected := make([]trackPoint, len(points))
+	for i, p := range points {
+		projected[i] = trackPoint{
+			X: (p.Lng - origin.Lng) * math.Pi / 180 * earthRadiusMetres * cosLat,
+			Y: (p.Lat - origin.Lat) * math.Pi / 180 * earthRadiusMetres,
+		}
+	}
+	return projected
+}
+
+// simplifyTrack drops points that lie within tolerance metres of the line through their
+// neighbours, using the Douglas-Peucker algorithm
+func simplifyTrack(points []trackPoint, tolerance float64) []trackPoint {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// An explicit stack rather than recursion, so long tracks can't blow the stack
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		furthest, furthestDistance := -1, tolerance
+		for i := s.first + 1; i < s.last; i++ {
+			if d := segmentDistance(points[i], points[s.first], points[s.last]); d > furthestDistance {
+				furthest, furthestDistance = i, d
+			}
+		}
+		if furthest >= 0 {
+			keep[furthest] = true
+			stack = append(stack, span{s.first, furthest}, span{furthest, s.last})
+		}
+	}
+
+	simplified := make([]trackPoint, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// directedHausdorff is the furthest any point of a is from track b. It stops early once the
+// distance is over limit.
+func directedHausdorff(a, b []trackPoint, limit float64) float64 {
+	var furthest float64
+	for _, p := range a {
+		nearest := math.Inf(1)
+		if len(b) == 1 {
+			nearest = math.Hypot(p.X-b[0].X, p.Y-b[0].Y)
+		}
+		for i := 1; i < len(b); i++ {
+			if d := segmentDistance(p, b[i-1], b[i]); d < nearest {
+				nearest = d
+			}
+		}
+		if nearest > furthest {
+			furthest = nearest
+			if furthest > limit {
+				return furthest
+			}
+		}
+	}
+	return furthest
+}
+
+// segmentDistance is the distance from p to the line segment from a to b
+func segmentDistance(p, a, b trackPoint) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
+
+// boundsGap is how far apart the bounding boxes of two tracks are, zero if they overlap. The
+// Hausdorff distance is never less, so it's a cheap way to rule out routes elsewhere.
+func boundsGap(a, b []trackPoint) float64 {
+	minA, maxA := trackBounds(a)
+	minB, maxB := trackBounds(b)
+	gapX := math.Max(0, math.Max(minA.X-maxB.X, minB.X-maxA.X))
+	gapY := math.Max(0, math.Max(minA.Y-maxB.Y, minB.Y-maxA.Y))
+	return math.Hypot(gapX, gapY)
+}
+
+// trackBounds returns the corners of a track's bounding box
+func trackBounds(points []trackPoint) (min, max trackPoint) {
+	min, max = points[0], points[0]
+	for _, p := range points[1:] {
+		min.X, min.Y = math.Min(min.X, p.X), math.Min(min.Y, p.Y)
+		max.X, max.Y = math.Max(max.X, p.X), math.Max(max.Y, p.Y)
+	}
+	return min, max
+}
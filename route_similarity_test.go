@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testTrack is a 5 km ride east along a line of latitude, moved north by offsetMetres
+func testTrack(offsetMetres float64) []latLng {
+	const lat, lng = 53.0, -1.5
+	dLat := offsetMetres / earthRadiusMetres * 180 / math.Pi
+	var track []latLng
+	for i := 0; i <= 10; i++ {
+		track = append(track, latLng{Lat: lat + dLat, Lng: lng + float64(i)*0.0075})
+	}
+	return track
+}
+
+// encodePolyline encodes points in Google's encoded polyline format, the inverse of decodePolyline
+func encodePolyline(points []latLng) string {
+	var out []byte
+	var prevLat, prevLng int64
+	for _, p := range points {
+		lat, lng := int64(math.Round(p.Lat*1e5)), int64(math.Round(p.Lng*1e5))
+		for _, delta := range []int64{lat - prevLat, lng - prevLng} {
+			v := delta << 1
+			if delta < 0 {
+				v = ^v
+			}
+			for v >= 0x20 {
+				out = append(out, byte(0x20|v&0x1f)+63)
+				v >>= 5
+			}
+			out = append(out, byte(v)+63)
+		}
+		prevLat, prevLng = lat, lng
+	}
+	return string(out)
+}
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    []latLng
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		// The example from Google's polyline format documentation
+		{"known polyline", "_p~iF~ps|U_ulLnnqC_mqNvxq`@", []latLng{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, false},
+		{"single point", "_p~iF~ps|U", []latLng{{38.5, -120.2}}, false},
+		{"truncated mid-value", "_p~iF~ps|U_ulL", nil, true},
+		{"truncated mid-point", "_p~iF~ps|U_ulLnnq", nil, true},
+		{"invalid character", "_p~iF ps|U", nil, true},
+		{"value too long", "~~~~~~~~~~~~~~~~", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePolyline(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i].Lat-tt.want[i].Lat) > 1e-9 || math.Abs(got[i].Lng-tt.want[i].Lng) > 1e-9 {
+					t.Errorf("point %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrackDistance(t *testing.T) {
+	base := testTrack(0)
+	tests := []struct {
+		name   string
+		other  []latLng
+		wantOK bool
+		want   float64 // Expected distance in metres, when wantOK
+	}{
+		{"identical", base, true, 0},
+		{"offset well inside the threshold", testTrack(100), true, 100},
+		{"offset just inside the threshold", testTrack(240), true, 240},
+		{"offset just outside the threshold", testTrack(260), false, 0},
+		{"far away", testTrack(5000), false, 0},
+		{"half the ride", base[:6], false, 0},
+		{"no points", nil, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := trackDistance(base, tt.other, similarRouteMaxDistance)
+			if ok != tt.wantOK {
+				t.Fatalf("got %.1f m, similar %v; want similar %v", got, ok, tt.wantOK)
+			}
+			if ok && math.Abs(got-tt.want) > 1 {
+				t.Errorf("got %.1f m, want %.1f m", got, tt.want)
+			}
+			if _, reverseOK := trackDistance(tt.other, base, similarRouteMaxDistance); reverseOK != ok {
+				t.Errorf("similar %v one way but %v the other", ok, reverseOK)
+			}
+		})
+	}
+}
+
+func TestSimplifyTrack(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []trackPoint
+		want   []trackPoint
+	}{
+		{"empty", nil, nil},
+		{"two points", []trackPoint{{0, 0}, {100, 0}}, []trackPoint{{0, 0}, {100, 0}}},
+		{"straight line", []trackPoint{{0, 0}, {50, 0}, {100, 0}, {150, 0}}, []trackPoint{{0, 0}, {150, 0}}},
+		{"wobble inside tolerance", []trackPoint{{0, 0}, {50, 20}, {100, -20}, {150, 0}}, []trackPoint{{0, 0}, {150, 0}}},
+		{"corner", []trackPoint{{0, 0}, {50, 0}, {100, 0}, {100, 50}, {100, 100}}, []trackPoint{{0, 0}, {100, 0}, {100, 100}}},
+		{"out and back", []trackPoint{{0, 0}, {500, 0}, {0, 10}}, []trackPoint{{0, 0}, {500, 0}, {0, 10}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := simplifyTrack(tt.points, polylineSimplifyTolerance)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNearbyBoundsFilterKeepsSimilarTracks(t *testing.T) {
+	base := polylineBounds(encodePolyline(testTrack(0)))
+	if base == nil {
+		t.Fatal("no bounds for the test track")
+	}
+	filter := nearbyBoundsFilter(base, similarRouteMaxDistance)
+	matches := func(b *RouteBounds) bool {
+		for field, value := range map[string]float64{
+			"bounds.minLat": b.MinLat, "bounds.maxLat": b.MaxLat,
+			"bounds.minLng": b.MinLng, "bounds.maxLng": b.MaxLng,
+		} {
+			r := filter[field].(bson.M)
+			if value < r["$gte"].(float64) || value > r["$lte"].(float64) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, offset := range []float64{0, -240, 240} {
+		if b := polylineBounds(encodePolyline(testTrack(offset))); !matches(b) {
+			t.Errorf("a track %.0f m away isn't a candidate", offset)
+		}
+	}
+	if b := polylineBounds(encodePolyline(testTrack(1000))); matches(b) {
+		t.Error("a track 1 km away is a candidate")
+	}
+	if polylineBounds("_p~iF~ps|U_ulL") != nil {
+		t.Error("got bounds for a truncated polyline")
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,67 +14,177 @@ import (
 
 // Route represents a Strava route submitted by a member
 type Route struct {
-	ID                  string    `bson:"_id,omitempty"` // MongoDB document ID (as hex string)
-	Name                string    `bson:"name"`
-	URL                 string    `bson:"url"`
-	Classify            string    `bson:"classify"`
-	SubmittedByUserID   string    `bson:"submittedByUserID"`
-	SubmittedByUserName string    `bson:"submittedByUserName"`
-	SubmittedAt         time.Time `bson:"submittedAt"`
+	ID                  string       `bson:"_id,omitempty"` // MongoDB document ID (as hex string)
+	Name                string       `bson:"name"`
+	URL                 string       `bson:"url"`
+	Classify            string       `bson:"classify"`
+	SubmittedByUserID   int64        `bson:"submittedByUserID"` // StravaID of the member who submitted it, or clubRouteOwnerID
+	SubmittedByUserName string       `bson:"-"`                 // The submitter's current name, see resolveSubmitterNames
+	SubmittedAt         time.Time    `bson:"submittedAt"`       // When the member first submitted it; sorts the route library
+	CreatedAt           time.Time    `bson:"createdAt"`
+	UpdatedAt           time.Time    `bson:"updatedAt"`          // Also guards against concurrent edits, see UpdateRoute
+	StravaRouteID       int64        `bson:"stravaRouteID"`      // For spotting duplicates, see route_similarity.go
+	Polyline            string       `bson:"polyline,omitempty"` // Strava's summary polyline; routes from before duplicate detection have none
+	Bounds              *RouteBounds `bson:"bounds,omitempty"`   // Bounding box of Polyline, set when the route is saved
+
+	Status         string    `bson:"status,omitempty"`     // See routeStatusPending etc.; routes from before approval have none and count as approved
+	ReviewNote     string    `bson:"reviewNote,omitempty"` // The curator's reason for rejecting or asking for changes
+	ReviewedByID   int64     `bson:"reviewedByID,omitempty"`
+	ReviewedByName string    `bson:"reviewedByName,omitempty"`
+	ReviewedAt     time.Time `bson:"reviewedAt,omitempty"`
+
+	DeletedAt     time.Time `bson:"deletedAt,omitempty"` // Set while the route is in the trash, see trash.go
+	DeletedByID   int64     `bson:"deletedByID,omitempty"`
+	DeletedByName string    `bson:"deletedByName,omitempty"`
 }
 
 const routesCollection = "routes" // MongoDB collection name
 
-// CreateRoute adds a new route document to MongoDB or updates an existing one
+// Route statuses. New routes wait for a route curator before they appear in the route library.
+const (
+	routeStatusPending          = "pending"
+	routeStatusApproved         = "approved"
+	routeStatusRejected         = "rejected"
+	routeStatusChangesRequested = "changes_requested" // The submitter can resubmit after making them
+)
+
+// approvedRoutesFilter matches routes in the route library, including those submitted before
+// routes needed approval
+var approvedRoutesFilter = bson.M{"status": bson.M{"$in": bson.A{routeStatusApproved, nil}}}
+
+// Routes whose submitter deleted their account can be handed over to the club
+const (
+	clubRouteOwnerID   int64 = 0 // Never a Strava athlete ID
+	clubRouteOwnerName       = "South Peaks CC"
+)
+
+// formerMemberName is shown for routes whose submitter can no longer be found
+const formerMemberName = "Former member"
+
+// IsSubmittedBy reports whether u submitted the route
+func (r *Route) IsSubmittedBy(u *User) bool {
+	return u != nil && r.SubmittedByUserID == u.StravaID
+}
+
+// IsApproved reports whether the route is in the route library
+func (r *Route) IsApproved() bool {
+	return r.Status == "" || r.Status == routeStatusApproved
+}
+
+// StatusLabel describes the route's status for its submitter
+func (r *Route) StatusLabel() string {
+	switch r.Status {
+	case routeStatusPending:
+		return "Awaiting approval"
+	case routeStatusRejected:
+		return "Rejected"
+	case routeStatusChangesRequested:
+		return "Changes requested"
+	}
+	return "Approved"
+}
+
+// CreateRoute adds a new route document to MongoDB
 func CreateRoute(ctx context.Context, route *Route) error {
-	coll := mongoDB.Collection(routesCollection)
-	if route.ID == "" {
-		route.SubmittedAt = time.Now()
-		res, err := coll.InsertOne(ctx, route)
-		if err != nil {
-			return fmt.Errorf("failed to create route document: %w", err)
-		}
-		if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
-			route.ID = oid.Hex()
-		}
-	} else {
-		objID, err := primitive.ObjectIDFromHex(route.ID)
-		if err != nil {
-			return fmt.Errorf("invalid route ID: %w", err)
-		}
-		route.SubmittedAt = time.Now()
-		_, err = coll.ReplaceOne(ctx, bson.M{"_id": objID}, route)
-		if err != nil {
-			return fmt.Errorf("failed to update route document %s: %w", route.ID, err)
-		}
+	now := time.Now()
+	route.SubmittedAt = now
+	route.CreatedAt = now
+	route.UpdatedAt = now
+	route.Bounds = polylineBounds(route.Polyline)
+	res, err := mongoDB.Collection(routesCollection).InsertOne(ctx, route)
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateError("route", route.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create route document: %w", err)
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		route.ID = oid.Hex()
 	}
 	return nil
 }
 
-// GetRouteByID retrieves a single route by its MongoDB document ID
+// UpdateRoute saves changes to an existing route, first recording the version it replaces as a
+// revision. previous is the route as it was loaded; if the route has changed since, e.g. a
+// curator reviewed it meanwhile, errRouteChanged is returned and nothing is saved.
+func UpdateRoute(ctx context.Context, previous, route *Route, editor *User, change string) error {
+	objID, err := parseObjectID("route", route.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	route.UpdatedAt = now
+	route.Bounds = polylineBounds(route.Polyline)
+	// The replacement leaves out the _id, which is stored as an ObjectID and can't be changed
+	replacement := *route
+	replacement.ID = ""
+	filter := bson.M{"_id": objID, "updatedAt": previous.UpdatedAt, "deletedAt": nil}
+	res, err := mongoDB.Collection(routesCollection).ReplaceOne(ctx, filter, &replacement)
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateError("route", route.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update route document %s: %w", route.ID, err)
+	}
+	if res.MatchedCount == 0 {
+		return errRouteChanged
+	}
+	return recordRouteRevision(ctx, previous, editor, change, now)
+}
+
+// GetRouteByID retrieves a single route by its MongoDB document ID. Routes in the trash aren't found.
 func GetRouteByID(ctx context.Context, routeID string) (*Route, error) {
 	coll := mongoDB.Collection(routesCollection)
-	objID, err := primitive.ObjectIDFromHex(routeID)
+	objID, err := parseObjectID("route", routeID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid route ID: %w", err)
+		return nil, err
 	}
 	var route Route
-	err = coll.FindOne(ctx, bson.M{"_id": objID}).Decode(&route)
+	err = coll.FindOne(ctx, bson.M{"_id": objID, "deletedAt": nil}).Decode(&route)
 	if err == mongo.ErrNoDocuments {
-		return nil, errors.New("route not found")
+		return nil, notFoundError("route", routeID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get route document: %w", err)
 	}
 	route.ID = objID.Hex()
-	return &route, nil
+	routes := []Route{route}
+	if err := resolveSubmitterNames(ctx, routes); err != nil {
+		return nil, err
+	}
+	return &routes[0], nil
 }
 
-// GetAllRoutes retrieves all routes from MongoDB, ordered by submission time
+// GetAllRoutes retrieves all routes from MongoDB, whatever their status, ordered by submission time
 func GetAllRoutes(ctx context.Context) ([]Route, error) {
+	return findRoutes(ctx, bson.D{}, bson.D{{Key: "submittedAt", Value: -1}})
+}
+
+// GetApprovedRoutes retrieves the routes in the club's route library, ordered by submission time
+func GetApprovedRoutes(ctx context.Context) ([]Route, error) {
+	return findRoutes(ctx, approvedRoutesFilter, bson.D{{Key: "submittedAt", Value: -1}})
+}
+
+// GetPendingRoutes retrieves routes waiting for a curator, oldest first so none are left waiting
+func GetPendingRoutes(ctx context.Context) ([]Route, error) {
+	return findRoutes(ctx, bson.M{"status": routeStatusPending}, bson.D{{Key: "submittedAt", Value: 1}})
+}
+
+// GetUserRoutes retrieves routes submitted by a specific user from MongoDB, whatever their status
+func GetUserRoutes(ctx context.Context, userID int64) ([]Route, error) {
+	return findRoutes(ctx, bson.M{"submittedByUserID": userID}, bson.D{{Key: "submittedAt", Value: -1}})
+}
+
+// findRoutes runs a query against the routes collection, leaving out routes in the trash
+func findRoutes(ctx context.Context, filter interface{}, sort bson.D, opts ...*options.FindOptions) ([]Route, error) {
+	return findRoutesIncludingDeleted(ctx, excludeDeleted(filter), sort, opts...)
+}
+
+// findRoutesIncludingDeleted runs a query against the routes collection as it is
+func findRoutesIncludingDeleted(ctx context.Context, filter interface{}, sort bson.D, opts ...*options.FindOptions) ([]Route, error) {
 	coll := mongoDB.Collection(routesCollection)
-	opts := options.Find().SetSort(bson.D{{Key: "submittedAt", Value: -1}})
-	cursor, err := coll.Find(ctx, bson.D{}, opts)
+	cursor, err := coll.Find(ctx, filter, append([]*options.FindOptions{options.Find().SetSort(sort)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("error finding routes: %w", err)
 	}
@@ -94,47 +204,209 @@ func GetAllRoutes(ctx context.Context) ([]Route, error) {
 	if err := cursor.Err(); err != nil {
 		return nil, fmt.Errorf("cursor error: %w", err)
 	}
+	if err := resolveSubmitterNames(ctx, routes); err != nil {
+		return nil, err
+	}
 	return routes, nil
 }
 
-// GetUserRoutes retrieves routes submitted by a specific user from MongoDB
-func GetUserRoutes(ctx context.Context, userID string) ([]Route, error) {
-	coll := mongoDB.Collection(routesCollection)
-	filter := bson.M{"submittedByUserID": userID}
-	opts := options.Find().SetSort(bson.D{{Key: "submittedAt", Value: -1}})
-	cursor, err := coll.Find(ctx, filter, opts)
+// ReviewRoute records a curator's decision on a pending route, keeping the pending version as a
+// revision. It reports false if the route is no longer pending, e.g. another curator reviewed it first.
+func ReviewRoute(ctx context.Context, route *Route, status, note string, reviewer *User, now time.Time) (bool, error) {
+	objID, err := parseObjectID("route", route.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error finding user routes: %w", err)
+		return false, err
 	}
-	defer cursor.Close(ctx)
+	filter := bson.M{"_id": objID, "status": routeStatusPending, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{
+		"status":         status,
+		"reviewNote":     note,
+		"reviewedByID":   reviewer.StravaID,
+		"reviewedByName": reviewer.DisplayName(),
+		"reviewedAt":     now,
+		"updatedAt":      now,
+	}}
+	res, err := mongoDB.Collection(routesCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to review route %s: %w", route.ID, err)
+	}
+	if res.ModifiedCount == 0 {
+		return false, nil
+	}
+	return true, recordRouteRevision(ctx, route, reviewer, routeChangeReviewed, now)
+}
 
-	var routes []Route
-	for cursor.Next(ctx) {
-		var route Route
-		if err := cursor.Decode(&route); err != nil {
-			return nil, fmt.Errorf("error decoding route: %w", err)
+// resolveSubmitterNames fills in the submitters' current names, looking them all up at once
+func resolveSubmitterNames(ctx context.Context, routes []Route) error {
+	ids := make([]int64, 0, len(routes))
+	for _, route := range routes {
+		if route.SubmittedByUserID != clubRouteOwnerID {
+			ids = append(ids, route.SubmittedByUserID)
 		}
-		if oid, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
-			route.ID = oid.Hex()
-		}
-		routes = append(routes, route)
 	}
-	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
+	names, err := GetUserNames(ctx, ids)
+	if err != nil {
+		return err
 	}
-	return routes, nil
+	for i := range routes {
+		routes[i].SubmittedByUserName = submitterName(routes[i].SubmittedByUserID, names)
+	}
+	return nil
 }
 
-// DeleteRoute deletes a route document from MongoDB
-func DeleteRoute(ctx context.Context, routeID string) error {
-	coll := mongoDB.Collection(routesCollection)
-	objID, err := primitive.ObjectIDFromHex(routeID)
+// submitterName is the name to show for a route's submitter, given their looked-up names
+func submitterName(id int64, names map[int64]string) string {
+	if id == clubRouteOwnerID {
+		return clubRouteOwnerName
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return formerMemberName
+}
+
+// routeNameCollation compares route names ignoring case, like the unique name index
+var routeNameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// ensureRouteIndexes indexes routes for members' route lists, for finding similar routes and by
+// name. Route names are unique ignoring case, so routes sharing a name are renamed first, see
+// renameDuplicateRouteNames.
+func ensureRouteIndexes(ctx context.Context) error {
+	renamed, err := renameDuplicateRouteNames(ctx)
 	if err != nil {
-		return fmt.Errorf("invalid route ID: %w", err)
+		return err
+	}
+	if renamed > 0 {
+		log.Printf("Renamed %d routes that shared a name", renamed)
 	}
-	_, err = coll.DeleteOne(ctx, bson.M{"_id": objID})
+
+	_, err = mongoDB.Collection(routesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "submittedByUserID", Value: 1}, {Key: "submittedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "stravaRouteID", Value: 1}}},
+		{Keys: bson.D{{Key: "bounds.minLat", Value: 1}, {Key: "bounds.minLng", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(routeNameCollation),
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete route document with ID %s: %w", routeID, err)
+		return fmt.Errorf("failed to create route indexes: %w", err)
 	}
 	return nil
 }
+
+// uniqueRouteName returns name, or if another route already has it, name followed by the first
+// free number, e.g. "Bakewell Loop (2)". Routes in the trash keep their names until purged.
+func uniqueRouteName(ctx context.Context, name string) (string, error) {
+	opts := options.Count().SetCollation(routeNameCollation)
+	candidate := name
+	for n := 2; ; n++ {
+		count, err := mongoDB.Collection(routesCollection).CountDocuments(ctx, bson.M{"name": candidate}, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to check route name %q: %w", candidate, err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+}
+
+// renameDuplicateRouteNames gives routes sharing a name, ignoring case, unique names: all but the
+// earliest submitted get a number after the name
+func renameDuplicateRouteNames(ctx context.Context) (int64, error) {
+	coll := mongoDB.Collection(routesCollection)
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "submittedAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$name", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetCollation(routeNameCollation))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find routes with the same name: %w", err)
+	}
+	var duplicates []struct {
+		Name string               `bson:"_id"`
+		IDs  []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return 0, fmt.Errorf("failed to decode routes with the same name: %w", err)
+	}
+
+	var renamed int64
+	for _, d := range duplicates {
+		for _, id := range d.IDs[1:] {
+			name, err := uniqueRouteName(ctx, d.Name)
+			if err != nil {
+				return renamed, err
+			}
+			if _, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"name": name}}); err != nil {
+				return renamed, fmt.Errorf("failed to rename route %s: %w", id.Hex(), err)
+			}
+			log.Printf("Renamed route %s from %q to %q so route names are unique", id.Hex(), d.Name, name)
+			renamed++
+		}
+	}
+	return renamed, nil
+}
+
+// backfillRouteTimestamps gives routes from before revisions were kept a createdAt and updatedAt,
+// taken from their submission date
+func backfillRouteTimestamps(ctx context.Context) (int64, error) {
+	filter := bson.M{"createdAt": bson.M{"$exists": false}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"createdAt": "$submittedAt", "updatedAt": "$submittedAt"}}}}
+	res, err := mongoDB.Collection(routesCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill route timestamps: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// backfillRouteStravaIDs gives routes from before duplicate detection their Strava route ID, taken
+// from the end of their URL
+func backfillRouteStravaIDs(ctx context.Context) (int64, error) {
+	filter := bson.M{"stravaRouteID": bson.M{"$exists": false}}
+	lastPart := bson.M{"$arrayElemAt": bson.A{bson.M{"$split": bson.A{"$url", "/"}}, -1}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"stravaRouteID": bson.M{"$convert": bson.M{"input": lastPart, "to": "long", "onError": int64(0), "onNull": int64(0)}},
+	}}}}
+	res, err := mongoDB.Collection(routesCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill route Strava IDs: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// migrateRouteSubmitterIDs converts submitter IDs stored as strings, from before routes referred
+// to members by their numeric Strava ID. The submitter's name was stored alongside and went stale
+// when they changed it, so it's dropped.
+func migrateRouteSubmitterIDs(ctx context.Context) (int64, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"submittedByUserID": bson.M{"$type": "string"}},
+		bson.M{"submittedByUserName": bson.M{"$exists": true}},
+	}}
+	// An ID that isn't a number can't be traced to a member, so the route is handed to the club
+	numericID := bson.M{"$convert": bson.M{
+		"input": "$submittedByUserID", "to": "long", "onError": clubRouteOwnerID, "onNull": clubRouteOwnerID,
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"submittedByUserID": numericID}}},
+		{{Key: "$unset", Value: "submittedByUserName"}},
+	}
+	res, err := mongoDB.Collection(routesCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert route submitter IDs: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// ReassignUserRoutesToClub transfers every route submitted by a user to the club
+func ReassignUserRoutesToClub(ctx context.Context, userID int64) (int64, error) {
+	coll := mongoDB.Collection(routesCollection)
+	update := bson.M{"$set": bson.M{"submittedByUserID": clubRouteOwnerID}}
+	res, err := coll.UpdateMany(ctx, bson.M{"submittedByUserID": userID}, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reassign routes for user %d: %w", userID, err)
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,12 @@
+// static/js/htmx-errors.js
+
+// htmx ignores error responses, so a failed request would otherwise do nothing visible. The server
+// answers failed HTMX requests with an error fragment and says where it goes (see writeStoreError),
+// so swap those in.
+document.addEventListener('htmx:beforeSwap', function(event) {
+  const xhr = event.detail.xhr;
+  if (xhr.status >= 400 && xhr.getResponseHeader('HX-Retarget')) {
+    event.detail.shouldSwap = true;
+    event.detail.isError = false;
+  }
+});
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	statsSignupMonths  = 12 // Months of sign-ups shown on the dashboard
+	statsTopSubmitters = 10
+)
+
+// StatCount is one labelled count in a dashboard breakdown
+type StatCount struct {
+	Label string `bson:"_id"`
+	Count int    `bson:"count"`
+}
+
+// DashboardStats are the club statistics shown on the admin dashboard
+type DashboardStats struct {
+	TotalMembers  int64
+	PaidMembers   int64
+	TotalRoutes   int64       // In the route library
+	PendingRoutes int64       // Awaiting a route curator
+	Signups       []StatCount // Per month, oldest first
+	LastLogin     []StatCount // Recency buckets, most recent first
+	RoutesByClass []StatCount
+	TopSubmitters []StatCount
+
+	SignupsChart       BarChart
+	LastLoginChart     BarChart
+	RoutesByClassChart BarChart
+	TopSubmitterChart  BarChart
+}
+
+// lastLoginBuckets are the recency buckets, in display order, with how recent a login must be
+var lastLoginBuckets = []struct {
+	Label  string
+	Within time.Duration
+}{
+	{"Past week", 7 * 24 * time.Hour},
+	{"Past month", 30 * 24 * time.Hour},
+	{"Past 3 months", 90 * 24 * time.Hour},
+	{"Past year", 365 * 24 * time.Hour},
+}
+
+const lastLoginOlderLabel = "Over a year ago"
+
+// buildDashboardStats runs the dashboard's aggregations
+func buildDashboardStats(ctx context.Context, now time.Time) (*DashboardStats, error) {
+	users := mongoDB.Collection(usersCollection)
+	routes := mongoDB.Collection(routesCollection)
+	stats := &DashboardStats{}
+
+	var err error
+	if stats.TotalMembers, err = users.CountDocuments(ctx, notDeletedFilter); err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+	if stats.PaidMembers, err = users.CountDocuments(ctx, bson.M{"isPaidMember": true, "deletedAt": nil}); err != nil {
+		return nil, fmt.Errorf("failed to count paid members: %w", err)
+	}
+	if stats.TotalRoutes, err = routes.CountDocuments(ctx, excludeDeleted(approvedRoutesFilter)); err != nil {
+		return nil, fmt.Errorf("failed to count routes: %w", err)
+	}
+	if stats.PendingRoutes, err = routes.CountDocuments(ctx, bson.M{"status": routeStatusPending, "deletedAt": nil}); err != nil {
+		return nil, fmt.Errorf("failed to count pending routes: %w", err)
+	}
+
+	if stats.Signups, err = signupsByMonth(ctx, users, now); err != nil {
+		return nil, err
+	}
+	if stats.LastLogin, err = lastLoginRecency(ctx, users, now); err != nil {
+		return nil, err
+	}
+
+	byClass := mongo.Pipeline{
+		{{Key: "$match", Value: excludeDeleted(approvedRoutesFilter)}},
+		{{Key: "$group", Value: bson.M{"_id": "$classify", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	if stats.RoutesByClass, err = aggregateCounts(ctx, routes, byClass); err != nil {
+		return nil, fmt.Errorf("failed to count routes by classification: %w", err)
+	}
+
+	if stats.TopSubmitters, err = topRouteSubmitters(ctx, routes); err != nil {
+		return nil, err
+	}
+
+	stats.SignupsChart = newBarChart(stats.Signups)
+	stats.LastLoginChart = newBarChart(stats.LastLogin)
+	stats.RoutesByClassChart = newBarChart(stats.RoutesByClass)
+	stats.TopSubmitterChart = newBarChart(stats.TopSubmitters)
+	return stats, nil
+}
+
+// signupsByMonth counts new members per month. Users have no created date, so it's taken from
+// the timestamp in their ObjectID.
+func signupsByMonth(ctx context.Context, users *mongo.Collection, now time.Time) ([]StatCount, error) {
+	firstMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(statsSignupMonths - 1), 0)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(firstMonth)}, "deletedAt": nil}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": bson.M{"$toDate": "$_id"}}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	counts, err := aggregateCounts(ctx, users, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sign-ups by month: %w", err)
+	}
+
+	// Fill in months with no sign-ups so the chart has a bar for every month
+	byMonth := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byMonth[c.Label] = c.Count
+	}
+	months := make([]StatCount, 0, statsSignupMonths)
+	for i := 0; i < statsSignupMonths; i++ {
+		month := firstMonth.AddDate(0, i, 0)
+		months = append(months, StatCount{Label: month.Format("Jan 2006"), Count: byMonth[month.Format("2006-01")]})
+	}
+	return months, nil
+}
+
+// lastLoginRecency buckets members by how recently they logged in
+func lastLoginRecency(ctx context.Context, users *mongo.Collection, now time.Time) ([]StatCount, error) {
+	var branches bson.A
+	for _, bucket := range lastLoginBuckets {
+		branches = append(branches, bson.M{
+			"case": bson.M{"$gte": bson.A{"$lastLogin", now.Add(-bucket.Within)}},
+			"then": bucket.Label,
+		})
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: notDeletedFilter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$switch": bson.M{"branches": branches, "default": lastLoginOlderLabel}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	counts, err := aggregateCounts(ctx, users, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bucket members by last login: %w", err)
+	}
+
+	byLabel := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byLabel[c.Label] = c.Count
+	}
+	buckets := make([]StatCount, 0, len(lastLoginBuckets)+1)
+	for _, bucket := range lastLoginBuckets {
+		buckets = append(buckets, StatCount{Label: bucket.Label, Count: byLabel[bucket.Label]})
+	}
+	return append(buckets, StatCount{Label: lastLoginOlderLabel, Count: byLabel[lastLoginOlderLabel]}), nil
+}
+
+// topRouteSubmitters counts approved routes by submitter, under the submitters' current names
+func topRouteSubmitters(ctx context.Context, routes *mongo.Collection) ([]StatCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: excludeDeleted(approvedRoutesFilter)}},
+		{{Key: "$group", Value: bson.M{"_id": "$submittedByUserID", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: statsTopSubmitters}},
+	}
+	cursor, err := routes.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find top route submitters: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var submitters []struct {
+		ID    int64 `bson:"_id"`
+		Count int   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &submitters); err != nil {
+		return nil, fmt.Errorf("failed to decode top route submitters: %w", err)
+	}
+
+	ids := make([]int64, len(submitters))
+	for i, s := range submitters {
+		ids[i] = s.ID
+	}
+	names, err := GetUserNames(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]StatCount, len(submitters))
+	for i, s := range submitters {
+		counts[i] = StatCount{Label: submitterName(s.ID, names), Count: s.Count}
+	}
+	return counts, nil
+}
+
+// aggregateCounts runs a pipeline whose results have a string _id and a count
+func aggregateCounts(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) ([]StatCount, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []StatCount
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	for i := range counts {
+		if counts[i].Label == "" {
+			counts[i].Label = "(none)"
+		}
+	}
+	return counts, nil
+}
+
+// BarChart is a horizontal bar chart laid out for rendering as SVG by the dashboard template
+type BarChart struct {
+	Width     int
+	Height    int
+	BarX      int // Where bars start
+	LabelX    int // Labels are right-aligned here, just left of the bars
+	BarHeight int
+	Bars      []ChartBar
+}
+
+// ChartBar is one bar, with its geometry in SVG user units
+type ChartBar struct {
+	Label    string
+	Value    int
+	Y        int // Top of the bar
+	TextY    int // Baseline for the label and value
+	BarWidth int
+	ValueX   int
+}
+
+// Chart layout, in SVG user units
+const (
+	chartWidth       = 600
+	chartLabelWidth  = 160 // Space for labels left of the bars
+	chartValueWidth  = 50  // Space for the value right of the longest bar
+	chartRowHeight   = 28
+	chartBarHeight   = 20
+	chartTextOffset  = 15 // Label baseline below the top of the row
+	chartMinBarWidth = 2  // Non-zero values stay visible
+)
+
+// newBarChart lays out a bar per count, scaled to the largest
+func newBarChart(counts []StatCount) BarChart {
+	chart := BarChart{Width: chartWidth, Height: len(counts) * chartRowHeight, BarX: chartLabelWidth, LabelX: chartLabelWidth - 8, BarHeight: chartBarHeight}
+	maxValue := 0
+	for _, c := range counts {
+		if c.Count > maxValue {
+			maxValue = c.Count
+		}
+	}
+	barSpace := chartWidth - chartLabelWidth - chartValueWidth
+	for i, c := range counts {
+		width := 0
+		if maxValue > 0 {
+			width = c.Count * barSpace / maxValue
+		}
+		if c.Count > 0 && width < chartMinBarWidth {
+			width = chartMinBarWidth
+		}
+		y := i * chartRowHeight
+		chart.Bars = append(chart.Bars, ChartBar{
+			Label:    c.Label,
+			Value:    c.Count,
+			Y:        y + (chartRowHeight-chartBarHeight)/2,
+			TextY:    y + chartTextOffset + (chartRowHeight-chartBarHeight)/2,
+			BarWidth: width,
+			ValueX:   chartLabelWidth + width + 6,
+		})
+	}
+	return chart
+}
+
+// adminDashboardHandler shows club statistics and links to the other admin pages
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permViewStats) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stats, err := buildDashboardStats(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Error building dashboard stats: %v", err)
+		http.Error(w, "Failed to load club statistics", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Stats:       stats,
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_dashboard.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_dashboard template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestNewBarChartScaling(t *testing.T) {
+	barSpace := chartWidth - chartLabelWidth - chartValueWidth
+	tests := []struct {
+		name       string
+		counts     []int
+		wantWidths []int
+	}{
+		{"empty", nil, nil},
+		{"all zero", []int{0, 0}, []int{0, 0}},
+		{"largest fills the space", []int{10, 5, 0}, []int{barSpace, barSpace / 2, 0}},
+		{"single value", []int{3}, []int{barSpace}},
+		{"tiny values stay visible", []int{1000, 1}, []int{barSpace, chartMinBarWidth}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make([]StatCount, len(tt.counts))
+			for i, c := range tt.counts {
+				counts[i] = StatCount{Label: "bar", Count: c}
+			}
+			chart := newBarChart(counts)
+			if chart.Height != len(counts)*chartRowHeight {
+				t.Errorf("Height = %d, want %d", chart.Height, len(counts)*chartRowHeight)
+			}
+			if len(chart.Bars) != len(tt.wantWidths) {
+				t.Fatalf("got %d bars, want %d", len(chart.Bars), len(tt.wantWidths))
+			}
+			for i, bar := range chart.Bars {
+				if bar.BarWidth != tt.wantWidths[i] {
+					t.Errorf("bar %d width = %d, want %d", i, bar.BarWidth, tt.wantWidths[i])
+				}
+				if bar.Value != tt.counts[i] {
+					t.Errorf("bar %d value = %d, want %d", i, bar.Value, tt.counts[i])
+				}
+				if bar.ValueX != chartLabelWidth+bar.BarWidth+6 {
+					t.Errorf("bar %d value at %d, want just right of the bar at %d", i, bar.ValueX, chartLabelWidth+bar.BarWidth+6)
+				}
+				if bar.ValueX > chartWidth-chartValueWidth+6 {
+					t.Errorf("bar %d value at %d leaves too little room before the chart edge", i, bar.ValueX)
+				}
+				if top := i * chartRowHeight; bar.Y < top || bar.Y+chartBarHeight > top+chartRowHeight {
+					t.Errorf("bar %d at y %d doesn't fit in its row from %d", i, bar.Y, top)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeProvider takes payments through Stripe Checkout. apiURL can point at any Stripe-compatible API.
+type stripeProvider struct {
+	secretKey     string
+	webhookSecret string
+	apiURL        string
+	client        *http.Client
+}
+
+// stripeSignatureTolerance is how old a webhook signature may be, to limit replayed notifications
+const stripeSignatureTolerance = 5 * time.Minute
+
+func newStripeProvider(secretKey, webhookSecret, apiURL string) *stripeProvider {
+	if apiURL == "" {
+		apiURL = "https://api.stripe.com"
+	}
+	return &stripeProvider{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		apiURL:        apiURL,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *stripeProvider) Name() string { return "stripe" }
+
+// CreateCheckout creates a one-off Checkout Session for the membership fee. The member and season
+// travel in the session metadata so the webhook can record the membership without any local state.
+func (p *stripeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error) {
+	form := url.Values{
+		"mode":                 {"payment"},
+		"success_url":          {req.SuccessURL},
+		"cancel_url":           {req.CancelURL},
+		"client_reference_id":  {strconv.FormatInt(req.StravaID, 10)},
+		"metadata[stravaID]":   {strconv.FormatInt(req.StravaID, 10)},
+		"metadata[season]":     {strconv.Itoa(req.Season)},
+		"metadata[membership]": {req.MembershipType},
+
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {"gbp"},
+		"line_items[0][price_data][unit_amount]":        {strconv.Itoa(req.AmountPence)},
+		"line_items[0][price_data][product_data][name]": {req.Description},
+	}
+	if req.Email != "" {
+		form.Set("customer_email", req.Email)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build checkout request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.SetBasicAuth(p.secretKey, "")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("stripe checkout request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("stripe checkout returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode stripe checkout session: %w", err)
+	}
+	return &CheckoutSession{ID: session.ID, URL: session.URL}, nil
+}
+
+// ParseWebhook verifies the Stripe-Signature header and returns the payment for completed, paid
+// checkout sessions. Other event types are acknowledged with a nil event.
+func (p *stripeProvider) ParseWebhook(r *http.Request) (*PaymentEvent, error) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 256*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if err := verifyStripeSignature(payload, r.Header.Get("Stripe-Signature"), p.webhookSecret, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID            string            `json:"id"`
+				PaymentStatus string            `json:"payment_status"`
+				AmountTotal   int               `json:"amount_total"`
+				Metadata      map[string]string `json:"metadata"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidWebhook, err)
+	}
+
+	session := event.Data.Object
+	if event.Type != "checkout.session.completed" || session.PaymentStatus != "paid" {
+		return nil, nil
+	}
+
+	stravaID, err := strconv.ParseInt(session.Metadata["stravaID"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: session %s has no stravaID metadata", errInvalidWebhook, session.ID)
+	}
+	season, err := strconv.Atoi(session.Metadata["season"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: session %s has no season metadata", errInvalidWebhook, session.ID)
+	}
+
+	return &PaymentEvent{
+		SessionID:      session.ID,
+		StravaID:       stravaID,
+		Season:         season,
+		MembershipType: session.Metadata["membership"],
+		AmountPence:    session.AmountTotal,
+	}, nil
+}
+
+// verifyStripeSignature checks a "t=<unix>,v1=<hex hmac>" header against the payload, following
+// Stripe's scheme: HMAC-SHA256 of "<t>.<payload>" keyed with the endpoint's signing secret.
+func verifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("%w: missing timestamp or signature", errInvalidWebhook)
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp", errInvalidWebhook)
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", errInvalidWebhook)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", errInvalidWebhook)
+}
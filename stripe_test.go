@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testStripeSignature(secret string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	const secret = "whsec_test"
+	const payload = `{"type": "checkout.session.completed"}`
+	now := time.Unix(1700000000, 0)
+	ts := now.Unix()
+	valid := testStripeSignature(secret, ts, payload)
+
+	tests := []struct {
+		name    string
+		payload string
+		header  string
+		wantErr bool
+	}{
+		{"valid", payload, fmt.Sprintf("t=%d,v1=%s", ts, valid), false},
+		{"valid with spaces and other schemes", payload, fmt.Sprintf("t=%d, v0=abc, v1=%s", ts, valid), false},
+		{"tampered body", `{"type": "checkout.session.expired"}`, fmt.Sprintf("t=%d,v1=%s", ts, valid), true},
+		{"wrong secret", payload, fmt.Sprintf("t=%d,v1=%s", ts, testStripeSignature("whsec_other", ts, payload)), true},
+		{"stale timestamp", payload, fmt.Sprintf("t=%d,v1=%s", ts-600, testStripeSignature(secret, ts-600, payload)), true},
+		{"future timestamp", payload, fmt.Sprintf("t=%d,v1=%s", ts+600, testStripeSignature(secret, ts+600, payload)), true},
+		{"one of several signatures valid", payload, fmt.Sprintf("t=%d,v1=%s,v1=%s,v1=nothex", ts, testStripeSignature("whsec_old", ts, payload), valid), false},
+		{"none of several signatures valid", payload, fmt.Sprintf("t=%d,v1=%s,v1=nothex", ts, testStripeSignature("whsec_old", ts, payload)), true},
+		{"signature for another timestamp", payload, fmt.Sprintf("t=%d,v1=%s", ts, testStripeSignature(secret, ts-1, payload)), true},
+		{"missing timestamp", payload, "v1=" + valid, true},
+		{"missing signature", payload, fmt.Sprintf("t=%d", ts), true},
+		{"non-numeric timestamp", payload, "t=soon,v1=" + valid, true},
+		{"malformed header", payload, "garbage", true},
+		{"empty header", payload, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyStripeSignature([]byte(tt.payload), tt.header, secret, now)
+			if tt.wantErr && !errors.Is(err, errInvalidWebhook) {
+				t.Errorf("got error %v, want errInvalidWebhook", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MembershipTier is an admin-configured kind of membership, e.g. senior or junior.
+// Membership.Type holds the tier's Key.
+type MembershipTier struct {
+	Key          string   `bson:"_id"` // e.g. "senior"
+	Name         string   `bson:"name"`
+	PricePence   int      `bson:"pricePence"`
+	Benefits     []string `bson:"benefits"`
+	Capabilities []string `bson:"capabilities"` // Features the tier unlocks, see the cap* constants
+	Eligibility  string   `bson:"eligibility"`  // Shown to members, e.g. "Under 18 on 1 January"
+	MinAge       int      `bson:"minAge"`       // Age on 1 January of the season, 0 for no limit
+	MaxAge       int      `bson:"maxAge"`       // 0 for no limit
+	SelfService  bool     `bson:"selfService"`  // Members can buy it online; otherwise an admin assigns it
+	SortOrder    int      `bson:"sortOrder"`
+}
+
+const tiersCollection = "membershipTiers" // MongoDB collection name
+
+// Capabilities a tier can grant
+const (
+	capViewRoutes   = "view_routes"
+	capSubmitRoutes = "submit_routes"
+)
+
+// Capability is a feature a tier can unlock, as listed in the admin tier editor
+type Capability struct {
+	Key         string
+	Description string
+}
+
+// allCapabilities lists every capability a tier can grant
+var allCapabilities = []Capability{
+	{capViewRoutes, "Browse the club's route library"},
+	{capSubmitRoutes, "Submit routes from Strava"},
+}
+
+// legacyCapabilities apply to memberships recorded before tiers existed (or for a tier since removed),
+// which were full memberships.
+var legacyCapabilities = []string{capViewRoutes, capSubmitRoutes}
+
+// defaultTiers are created the first time the site starts with an empty tiers collection
+var defaultTiers = []MembershipTier{
+	{Key: "senior", Name: "Senior", PricePence: 1600, SortOrder: 1, SelfService: true,
+		Eligibility:  "18 or over on 1 January",
+		Benefits:     []string{"Club rides and events", "Route library access", "Club kit discount"},
+		Capabilities: []string{capViewRoutes, capSubmitRoutes}},
+	{Key: "junior", Name: "Junior", PricePence: 800, SortOrder: 2, SelfService: true, MaxAge: 17,
+		Eligibility:  "Under 18 on 1 January, riding with a parent or guardian",
+		Benefits:     []string{"Club rides with a parent or guardian", "Route library access"},
+		Capabilities: []string{capViewRoutes}},
+	{Key: "family", Name: "Family", PricePence: 3000, SortOrder: 3,
+		Eligibility:  "Up to two adults and their children at one address. Ask the membership secretary.",
+		Benefits:     []string{"Membership for the whole household", "Route library access"},
+		Capabilities: []string{capViewRoutes, capSubmitRoutes}},
+	{Key: "second-claim", Name: "Second Claim", PricePence: 1000, SortOrder: 4, SelfService: true,
+		Eligibility:  "First-claim member of another club",
+		Benefits:     []string{"Club rides and events", "Route library access"},
+		Capabilities: []string{capViewRoutes, capSubmitRoutes}},
+}
+
+// tierCache holds the tiers in memory; they are read on most requests and change rarely
+var tierCache struct {
+	sync.RWMutex
+	tiers []MembershipTier
+}
+
+// loadTiers refreshes the in-memory tier cache from MongoDB
+func loadTiers(ctx context.Context) error {
+	opts := options.Find().SetSort(bson.D{{Key: "sortOrder", Value: 1}})
+	cursor, err := mongoDB.Collection(tiersCollection).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return fmt.Errorf("error finding membership tiers: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tiers []MembershipTier
+	if err := cursor.All(ctx, &tiers); err != nil {
+		return fmt.Errorf("error decoding membership tiers: %w", err)
+	}
+
+	tierCache.Lock()
+	tierCache.tiers = tiers
+	tierCache.Unlock()
+	return nil
+}
+
+// seedDefaultTiers creates the default tiers if none have been configured yet
+func seedDefaultTiers(ctx context.Context) error {
+	coll := mongoDB.Collection(tiersCollection)
+	count, err := coll.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("failed to count membership tiers: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(defaultTiers))
+	for i, tier := range defaultTiers {
+		docs[i] = tier
+	}
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to seed default membership tiers: %w", err)
+	}
+	log.Printf("Seeded %d default membership tiers", len(docs))
+	return nil
+}
+
+// SaveTier creates or replaces a tier and refreshes the cache
+func SaveTier(ctx context.Context, tier *MembershipTier) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := mongoDB.Collection(tiersCollection).ReplaceOne(ctx, bson.M{"_id": tier.Key}, tier, opts)
+	if err != nil {
+		return fmt.Errorf("failed to save membership tier %s: %w", tier.Key, err)
+	}
+	return loadTiers(ctx)
+}
+
+// allTiers returns every configured tier in display order
+func allTiers() []MembershipTier {
+	tierCache.RLock()
+	defer tierCache.RUnlock()
+	tiers := make([]MembershipTier, len(tierCache.tiers))
+	copy(tiers, tierCache.tiers)
+	return tiers
+}
+
+// tierByKey returns the tier with the given key, or nil if there is none
+func tierByKey(key string) *MembershipTier {
+	tierCache.RLock()
+	defer tierCache.RUnlock()
+	for i := range tierCache.tiers {
+		if tierCache.tiers[i].Key == key {
+			tier := tierCache.tiers[i]
+			return &tier
+		}
+	}
+	return nil
+}
+
+// defaultTierKey is the tier recorded when an admin marks someone paid without choosing one
+const defaultTierKey = "senior"
+
+// defaultTier returns the tier admins record by default, falling back to the first tier
+// if the senior tier has been renamed. It is nil only if no tiers are configured.
+func defaultTier() *MembershipTier {
+	if tier := tierByKey(defaultTierKey); tier != nil {
+		return tier
+	}
+	tiers := allTiers()
+	if len(tiers) == 0 {
+		return nil
+	}
+	return &tiers[0]
+}
+
+// PricePounds formats the price for display
+func (t MembershipTier) PricePounds() string {
+	return "£" + t.PriceDecimal()
+}
+
+// PriceDecimal formats the price without a currency symbol, e.g. for payment links
+func (t MembershipTier) PriceDecimal() string {
+	return fmt.Sprintf("%d.%02d", t.PricePence/100, t.PricePence%100)
+}
+
+// Grants reports whether the tier unlocks a capability
+func (t MembershipTier) Grants(capability string) bool {
+	for _, c := range t.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// EligibleFor reports whether a member may buy the tier themselves for a season, and why not.
+// Age limits need a date of birth; without one the member is sent to the membership secretary.
+func (t MembershipTier) EligibleFor(u *User, season int) (bool, string) {
+	if !t.SelfService {
+		return false, "Ask the membership secretary to arrange this membership"
+	}
+	if t.MinAge == 0 && t.MaxAge == 0 {
+		return true, ""
+	}
+	if u.DateOfBirth.IsZero() {
+		return false, "We need your date of birth to check eligibility"
+	}
+	age := ageOn(u.DateOfBirth, seasonStart(season))
+	if t.MinAge > 0 && age < t.MinAge {
+		return false, fmt.Sprintf("For members aged %d or over", t.MinAge)
+	}
+	if t.MaxAge > 0 && age > t.MaxAge {
+		return false, fmt.Sprintf("For members aged %d or under", t.MaxAge)
+	}
+	return true, ""
+}
+
+// ageOn returns someone's age in whole years on the given date
+func ageOn(dateOfBirth, on time.Time) int {
+	age := on.Year() - dateOfBirth.Year()
+	if on.Month() < dateOfBirth.Month() || (on.Month() == dateOfBirth.Month() && on.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return age
+}
+
+// CurrentTier returns the tier of the member's current membership, or nil if they have none
+// or it was recorded against a tier that no longer exists.
+func (u *User) CurrentTier() *MembershipTier {
+	m := u.CurrentMembership(time.Now())
+	if m == nil {
+		return nil
+	}
+	return tierByKey(m.Type)
+}
+
+// CurrentTierName is the member's tier for display, empty if they aren't a paid member
+func (u *User) CurrentTierName() string {
+	if !u.IsPaidMember {
+		return ""
+	}
+	if tier := u.CurrentTier(); tier != nil {
+		return tier.Name
+	}
+	return "Member"
+}
+
+// TierName is the name of the tier the membership was recorded against, falling back to
+// the stored key for legacy memberships and removed tiers
+func (m Membership) TierName() string {
+	if tier := tierByKey(m.Type); tier != nil {
+		return tier.Name
+	}
+	return m.Type
+}
+
+// HasCapability reports whether the member's current membership unlocks a feature
+func (u *User) HasCapability(capability string) bool {
+	m := u.CurrentMembership(time.Now())
+	if m == nil {
+		return false
+	}
+	if tier := tierByKey(m.Type); tier != nil {
+		return tier.Grants(capability)
+	}
+	for _, c := range legacyCapabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// TierOption is a tier offered to a member on the members page
+type TierOption struct {
+	Tier     MembershipTier
+	Eligible bool
+	Reason   string // Why the member can't buy it themselves
+}
+
+// tierOptionsFor lists every tier with the member's eligibility for the season
+func tierOptionsFor(u *User, season int) []TierOption {
+	var options []TierOption
+	for _, tier := range allTiers() {
+		eligible, reason := tier.EligibleFor(u, season)
+		options = append(options, TierOption{Tier: tier, Eligible: eligible, Reason: reason})
+	}
+	return options
+}
+
+// adminTiersHandler shows the tier configuration page
+func adminTiersHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageTiers) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data := TemplateData{
+		Location:     "Borrowash, Derbyshire",
+		CurrentYear:  time.Now().Year(),
+		IsLoggedIn:   true,
+		User:         user,
+		IsAdmin:      user.IsAdmin,
+		Tiers:        allTiers(),
+		Capabilities: allCapabilities,
+		CSSVersion:   cssVersion,
+	}
+
+	err := tmpl.ExecuteTemplate(w, "admin_tiers.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_tiers template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminSaveTierHandler creates or updates a tier from the tier editor form
+func adminSaveTierHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageTiers) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	tier, err := tierFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	before := tierByKey(tier.Key)
+	if err := SaveTier(ctx, tier); err != nil {
+		log.Printf("Error saving membership tier %s: %v", tier.Key, err)
+		http.Error(w, "Failed to save tier", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("Created tier %s (%s)", tier.Name, tier.PricePounds())
+	if before != nil {
+		details = fmt.Sprintf("Updated tier %s (%s, was %s)", tier.Name, tier.PricePounds(), before.PricePounds())
+	}
+	audit := &AuditEntry{
+		ActorID:   user.StravaID,
+		ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Action:    auditActionTierSaved,
+		TargetID:  tier.Key,
+		Details:   details,
+		After:     tier.auditValues(),
+	}
+	if before != nil {
+		audit.Before = before.auditValues()
+	}
+	if err := RecordAudit(ctx, audit); err != nil {
+		log.Printf("Error recording audit entry for tier %s: %v", tier.Key, err)
+	}
+
+	http.Redirect(w, r, "/admin/tiers", http.StatusSeeOther)
+}
+
+// auditValues summarises the tier for its audit entries
+func (t MembershipTier) auditValues() map[string]string {
+	return map[string]string{
+		"name":         t.Name,
+		"price":        t.PricePounds(),
+		"ages":         fmt.Sprintf("%d-%d", t.MinAge, t.MaxAge),
+		"selfService":  strconv.FormatBool(t.SelfService),
+		"capabilities": strings.Join(t.Capabilities, ","),
+	}
+}
+
+// tierFromForm parses and validates the tier editor form
+func tierFromForm(r *http.Request) (*MembershipTier, error) {
+	key := strings.ToLower(strings.TrimSpace(r.FormValue("key")))
+	if key == "" || strings.ContainsAny(key, " ./$") {
+		return nil, fmt.Errorf("tier key must be a single word, e.g. senior")
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return nil, fmt.Errorf("tier name is required")
+	}
+
+	price, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(r.FormValue("price")), "£"), 64)
+	if err != nil || price < 0 {
+		return nil, fmt.Errorf("invalid price")
+	}
+	minAge, _ := strconv.Atoi(r.FormValue("minAge"))
+	maxAge, _ := strconv.Atoi(r.FormValue("maxAge"))
+	if minAge < 0 || maxAge < 0 || (maxAge > 0 && minAge > maxAge) {
+		return nil, fmt.Errorf("invalid age range")
+	}
+	sortOrder, _ := strconv.Atoi(r.FormValue("sortOrder"))
+
+	var benefits []string
+	for _, line := range strings.Split(r.FormValue("benefits"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			benefits = append(benefits, line)
+		}
+	}
+
+	var capabilities []string
+	for _, c := range allCapabilities {
+		for _, selected := range r.Form["capabilities"] {
+			if selected == c.Key {
+				capabilities = append(capabilities, c.Key)
+			}
+		}
+	}
+	sort.Strings(capabilities)
+
+	return &MembershipTier{
+		Key:          key,
+		Name:         name,
+		PricePence:   int(price*100 + 0.5),
+		Benefits:     benefits,
+		Capabilities: capabilities,
+		Eligibility:  strings.TrimSpace(r.FormValue("eligibility")),
+		MinAge:       minAge,
+		MaxAge:       maxAge,
+		SelfService:  r.FormValue("selfService") == "on",
+		SortOrder:    sortOrder,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	tokenRefreshInterval = 15 * time.Minute // How often the background refresher runs
+	tokenRefreshWindow   = 30 * time.Minute // Refresh tokens expiring within this window
+)
+
+// startStravaTokenRefresher runs refreshExpiringStravaTokens periodically until ctx is cancelled
+func startStravaTokenRefresher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tokenRefreshInterval)
+		defer ticker.Stop()
+
+		refreshExpiringStravaTokens(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Strava token refresher stopped")
+				return
+			case <-ticker.C:
+				refreshExpiringStravaTokens(ctx)
+			}
+		}
+	}()
+}
+
+// refreshExpiringStravaTokens refreshes every connected user's token that is close to expiry.
+// Users whose refresh token has been revoked are flagged as disconnected by RefreshStravaToken.
+func refreshExpiringStravaTokens(ctx context.Context) {
+	users, err := GetUsersWithExpiringTokens(ctx, time.Now().Add(tokenRefreshWindow))
+	if err != nil {
+		log.Printf("Error finding users with expiring Strava tokens: %v", err)
+		return
+	}
+
+	refreshed, disconnected := 0, 0
+	for i := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := RefreshStravaToken(ctx, &users[i]); err != nil {
+			if errors.Is(err, errStravaAuthRevoked) {
+				disconnected++
+				continue
+			}
+			log.Printf("Background refresh failed for user %d: %v", users[i].StravaID, err)
+			continue
+		}
+		refreshed++
+	}
+
+	if len(users) > 0 {
+		log.Printf("Strava token refresher: %d refreshed, %d disconnected, %d checked", refreshed, disconnected, len(users))
+	}
+}
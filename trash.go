@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Deleted routes and accounts are marked with deletedAt and kept in the trash for trashRetention,
+// during which an admin can restore them, before they are purged for good.
+const (
+	defaultTrashRetentionDays = 30
+	trashPurgeInterval        = time.Hour // How often the purge worker looks for expired items
+)
+
+// trashRetention is how long deleted items are kept, set by TRASH_RETENTION_DAYS
+var trashRetention = defaultTrashRetentionDays * 24 * time.Hour
+
+// notDeletedFilter matches documents that aren't in the trash
+var notDeletedFilter = bson.M{"deletedAt": nil}
+
+// inTrashFilter matches documents that are in the trash
+var inTrashFilter = bson.M{"deletedAt": bson.M{"$ne": nil}}
+
+// excludeDeleted narrows a query to documents that aren't in the trash
+func excludeDeleted(filter interface{}) bson.M {
+	return bson.M{"$and": bson.A{filter, notDeletedFilter}}
+}
+
+// trashRetentionFromEnv reads TRASH_RETENTION_DAYS, keeping the default when it's unset
+func trashRetentionFromEnv() (time.Duration, error) {
+	value := os.Getenv("TRASH_RETENTION_DAYS")
+	if value == "" {
+		return trashRetention, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return 0, fmt.Errorf("TRASH_RETENTION_DAYS must be a whole number of days, at least 1: %q", value)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// trashRetentionDays is the retention period in whole days, for display
+func trashRetentionDays() int {
+	return int(trashRetention.Hours() / 24)
+}
+
+// trashTime is when something is deleted. MongoDB stores times to the millisecond, so the time is
+// truncated to match exactly when routes deleted along with an account are restored with it.
+func trashTime() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+// SoftDeleteRoute moves a route to the trash
+func SoftDeleteRoute(ctx context.Context, routeID string, deletedBy *User, now time.Time) error {
+	objID, err := parseObjectID("route", routeID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objID, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{
+		"deletedAt":     now,
+		"deletedByID":   deletedBy.StravaID,
+		"deletedByName": fmt.Sprintf("%s %s", deletedBy.FirstName, deletedBy.LastName),
+	}}
+	if _, err := mongoDB.Collection(routesCollection).UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to delete route %s: %w", routeID, err)
+	}
+	return nil
+}
+
+// SoftDeleteUserRoutes moves every route a member submitted to the trash along with their account.
+// They share the account's deletedAt, so restoring the account restores them too.
+func SoftDeleteUserRoutes(ctx context.Context, user *User, now time.Time) (int64, error) {
+	filter := bson.M{"submittedByUserID": user.StravaID, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{
+		"deletedAt":     now,
+		"deletedByID":   user.StravaID,
+		"deletedByName": fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+	}}
+	res, err := mongoDB.Collection(routesCollection).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete routes for user %d: %w", user.StravaID, err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// SoftDeleteUser moves a member's account to the trash. Their Strava tokens are discarded straight
+// away, as the club's access has been revoked.
+func SoftDeleteUser(ctx context.Context, stravaID int64, now time.Time) error {
+	filter := bson.M{"stravaID": stravaID, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{
+		"deletedAt":          now,
+		"accessToken":        "",
+		"refreshToken":       "",
+		"stravaDisconnected": true,
+	}}
+	if _, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to delete user %d: %w", stravaID, err)
+	}
+	return nil
+}
+
+// GetDeletedRoutes retrieves the routes in the trash, most recently deleted first
+func GetDeletedRoutes(ctx context.Context) ([]Route, error) {
+	return findRoutesIncludingDeleted(ctx, inTrashFilter, bson.D{{Key: "deletedAt", Value: -1}})
+}
+
+// GetDeletedUsers retrieves the accounts in the trash, most recently deleted first
+func GetDeletedUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}})
+	cursor, err := mongoDB.Collection(usersCollection).Find(ctx, inTrashFilter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding deleted users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error decoding deleted users: %w", err)
+	}
+	return users, nil
+}
+
+// RestoreRoute takes a route out of the trash. It returns nil if the route isn't in the trash.
+func RestoreRoute(ctx context.Context, routeID string) (*Route, error) {
+	objID, err := parseObjectID("route", routeID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": objID, "deletedAt": bson.M{"$ne": nil}}
+	update := bson.M{"$unset": bson.M{"deletedAt": "", "deletedByID": "", "deletedByName": ""}}
+	var route Route
+	err = mongoDB.Collection(routesCollection).FindOneAndUpdate(ctx, filter, update).Decode(&route)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore route %s: %w", routeID, err)
+	}
+	route.ID = objID.Hex()
+	return &route, nil
+}
+
+// RestoreUser takes an account out of the trash, along with the routes and emails deleted with it. It returns
+// nil if the account isn't in the trash. The member has to log in with Strava again, as the club's
+// access was revoked when they deleted it.
+func RestoreUser(ctx context.Context, stravaID int64) (*User, error) {
+	filter := bson.M{"stravaID": stravaID, "deletedAt": bson.M{"$ne": nil}}
+	update := bson.M{"$unset": bson.M{"deletedAt": ""}}
+	var user User
+	err := mongoDB.Collection(usersCollection).FindOneAndUpdate(ctx, filter, update).Decode(&user) // As it was, for deletedAt
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore user %d: %w", stravaID, err)
+	}
+
+	routesFilter := bson.M{"submittedByUserID": stravaID, "deletedAt": user.DeletedAt}
+	routesUpdate := bson.M{"$unset": bson.M{"deletedAt": "", "deletedByID": "", "deletedByName": ""}}
+	if _, err := mongoDB.Collection(routesCollection).UpdateMany(ctx, routesFilter, routesUpdate); err != nil {
+		return nil, fmt.Errorf("failed to restore routes for user %d: %w", stravaID, err)
+	}
+	emailsFilter := bson.M{"userID": stravaID, "deletedAt": user.DeletedAt}
+	if _, err := mongoDB.Collection(outboxCollection).UpdateMany(ctx, emailsFilter, bson.M{"$unset": bson.M{"deletedAt": ""}}); err != nil {
+		return nil, fmt.Errorf("failed to restore emails for user %d: %w", stravaID, err)
+	}
+
+	user.DeletedAt = time.Time{}
+	derivePaidStatus(&user)
+	deriveAdminStatus(&user)
+	return &user, nil
+}
+
+// PurgeRoute permanently deletes a route in the trash, and its history. It returns nil if the
+// route isn't in the trash.
+func PurgeRoute(ctx context.Context, routeID string) (*Route, error) {
+	objID, err := parseObjectID("route", routeID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": objID, "deletedAt": bson.M{"$ne": nil}}
+	var route Route
+	err = mongoDB.Collection(routesCollection).FindOneAndDelete(ctx, filter).Decode(&route)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge route %s: %w", routeID, err)
+	}
+	route.ID = objID.Hex()
+	return &route, DeleteRouteRevisions(ctx, route.ID)
+}
+
+// PurgeUser permanently deletes an account in the trash, along with the routes and emails deleted with it.
+// It returns nil if the account isn't in the trash.
+func PurgeUser(ctx context.Context, stravaID int64) (*User, error) {
+	filter := bson.M{"stravaID": stravaID, "deletedAt": bson.M{"$ne": nil}}
+	var user User
+	err := mongoDB.Collection(usersCollection).FindOneAndDelete(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge user %d: %w", stravaID, err)
+	}
+
+	routesFilter := bson.M{"submittedByUserID": stravaID, "deletedAt": user.DeletedAt}
+	if _, err := purgeRoutes(ctx, routesFilter); err != nil {
+		return nil, err
+	}
+	emailsFilter := bson.M{"userID": stravaID, "deletedAt": user.DeletedAt}
+	if _, err := mongoDB.Collection(outboxCollection).DeleteMany(ctx, emailsFilter); err != nil {
+		return nil, fmt.Errorf("failed to purge emails for user %d: %w", stravaID, err)
+	}
+	return &user, nil
+}
+
+// purgeRoutes permanently deletes the routes matching filter and their history
+func purgeRoutes(ctx context.Context, filter bson.M) (int64, error) {
+	routes, err := findRoutesIncludingDeleted(ctx, filter, bson.D{{Key: "_id", Value: 1}})
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for _, route := range routes {
+		objID, err := parseObjectID("route", route.ID)
+		if err != nil {
+			return purged, err
+		}
+		if _, err := mongoDB.Collection(routesCollection).DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+			return purged, fmt.Errorf("failed to purge route %s: %w", route.ID, err)
+		}
+		if err := DeleteRouteRevisions(ctx, route.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeExpiredTrash permanently deletes accounts and routes deleted before the retention period
+func purgeExpiredTrash(ctx context.Context, now time.Time) (users, routes int64, err error) {
+	expired := bson.M{"deletedAt": bson.M{"$ne": nil, "$lt": now.Add(-trashRetention)}}
+
+	res, err := mongoDB.Collection(usersCollection).DeleteMany(ctx, expired)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+	// Routes and emails deleted with those accounts have the same deletedAt, so they expire now too
+	if _, err := mongoDB.Collection(outboxCollection).DeleteMany(ctx, expired); err != nil {
+		return res.DeletedCount, 0, fmt.Errorf("failed to purge deleted emails: %w", err)
+	}
+	routes, err = purgeRoutes(ctx, expired)
+	return res.DeletedCount, routes, err
+}
+
+// startTrashPurgeWorker runs purgeExpiredTrash periodically until ctx is cancelled
+func startTrashPurgeWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			users, routes, err := purgeExpiredTrash(ctx, time.Now())
+			if err != nil {
+				log.Printf("Error purging the trash: %v", err)
+			} else if users > 0 || routes > 0 {
+				log.Printf("Trash purge: %d accounts and %d routes deleted permanently", users, routes)
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Println("Trash purge worker stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// TrashView is the admin trash page's contents
+type TrashView struct {
+	Routes        []Route
+	Users         []User
+	RetentionDays int
+}
+
+// PurgeDate is when something deleted at deletedAt will be purged
+func (t *TrashView) PurgeDate(deletedAt time.Time) time.Time {
+	return deletedAt.AddDate(0, 0, t.RetentionDays)
+}
+
+// loadTrashView gathers everything in the trash
+func loadTrashView(ctx context.Context) (*TrashView, error) {
+	routes, err := GetDeletedRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := GetDeletedUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &TrashView{Routes: routes, Users: users, RetentionDays: trashRetentionDays()}, nil
+}
+
+// adminTrashHandler shows deleted routes and accounts
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageTrash) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	trash, err := loadTrashView(r.Context())
+	if err != nil {
+		log.Printf("Error loading the trash: %v", err)
+		http.Error(w, "Failed to load the trash", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Location:    "Borrowash, Derbyshire",
+		CurrentYear: time.Now().Year(),
+		IsLoggedIn:  true,
+		User:        user,
+		IsAdmin:     user.IsAdmin,
+		Trash:       trash,
+		CSSVersion:  cssVersion,
+	}
+
+	err = tmpl.ExecuteTemplate(w, "admin_trash.html", data)
+	if err != nil {
+		log.Printf("Error executing admin_trash template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// adminTrashActionHandler restores or permanently deletes a route or account in the trash
+func adminTrashActionHandler(w http.ResponseWriter, r *http.Request) {
+	user, isLoggedIn := getUserFromSession(r)
+	if !isLoggedIn || !user.Can(permManageTrash) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	action := r.FormValue("action")
+	kind := r.FormValue("kind")
+	id := r.FormValue("id")
+	if action != "restore" && action != "purge" {
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	var err error
+	switch kind {
+	case "route":
+		var route *Route
+		if action == "restore" {
+			route, err = RestoreRoute(ctx, id)
+		} else {
+			route, err = PurgeRoute(ctx, id)
+		}
+		if route != nil {
+			name = route.Name
+		}
+	case "account":
+		stravaID, parseErr := strconv.ParseInt(id, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		var member *User
+		if action == "restore" {
+			member, err = RestoreUser(ctx, stravaID)
+		} else {
+			member, err = PurgeUser(ctx, stravaID)
+		}
+		if member != nil {
+			name = fmt.Sprintf("%s %s", member.FirstName, member.LastName)
+		}
+	default:
+		http.Error(w, "Invalid item", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error (%s) %s %s from the trash: %v", action, kind, id, err)
+		http.Error(w, "Failed to update the trash", http.StatusInternalServerError)
+		return
+	}
+
+	notice := "That item is no longer in the trash."
+	if name != "" {
+		auditAction := auditActionTrashRestored
+		notice = fmt.Sprintf("Restored %s.", name)
+		if action == "purge" {
+			auditAction = auditActionTrashPurged
+			notice = fmt.Sprintf("Permanently deleted %s.", name)
+		}
+		log.Printf("User %d %sd %s %s (%s) from the trash", user.StravaID, action, kind, id, name)
+
+		audit := &AuditEntry{
+			ActorID:   user.StravaID,
+			ActorName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+			Action:    auditAction,
+			TargetID:  id,
+			Details:   fmt.Sprintf("%s: %s", kind, name),
+		}
+		if err := RecordAudit(ctx, audit); err != nil {
+			log.Printf("Error recording audit entry for %s of %s %s: %v", action, kind, id, err)
+		}
+	}
+
+	trash, err := loadTrashView(ctx)
+	if err != nil {
+		log.Printf("Error loading the trash: %v", err)
+		http.Error(w, "Failed to load the trash", http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		User:    user,
+		IsAdmin: user.IsAdmin,
+		Trash:   trash,
+		Notice:  notice,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = tmpl.ExecuteTemplate(w, "trash_fragment.html", data)
+	if err != nil {
+		log.Printf("Error executing trash_fragment template: %v", err)
+		http.Error(w, "Failed to render the trash", http.StatusInternalServerError)
+	}
+}
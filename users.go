@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/oauth2"
@@ -19,55 +23,384 @@ type User struct {
 	FirstName      string    `bson:"firstName"`
 	LastName       string    `bson:"lastName"`
 	ProfilePicURL  string    `bson:"profilePicURL"`
-	IsPaidMember   bool      `bson:"isPaidMember"`
-	IsAdmin        bool      `bson:"isAdmin"`
+	IsPaidMember   bool      `bson:"isPaidMember"` // Derived from Memberships when loaded, see derivePaidStatus
+	IsAdmin        bool      `bson:"isAdmin"`      // Derived from Roles when loaded, see deriveAdminStatus
 	LastLogin      time.Time `bson:"lastLogin"`
 	AccessToken    string    `bson:"accessToken"`    // Stored token
 	RefreshToken   string    `bson:"refreshToken"`   // Stored token
 	AccessTokenExp time.Time `bson:"accessTokenExp"` // When token expires
+
+	StravaDisconnected   bool      `bson:"stravaDisconnected"`   // Strava rejected our refresh token; member must log in again
+	StravaDisconnectedAt time.Time `bson:"stravaDisconnectedAt"` // When the revocation was detected
+
+	Privacy     PrivacySettings `bson:"privacy"`
+	DateOfBirth time.Time       `bson:"dateOfBirth"` // Zero if unknown; used for age-limited membership tiers
+	Profile     MemberProfile   `bson:"profile"`     // Collected by the onboarding wizard, see onboarding.go
+
+	Memberships []Membership `bson:"memberships"` // One per paid season
+
+	Roles []string `bson:"roles"` // Granted roles beyond member, see roles.go
+
+	Email                   string    `bson:"email"`           // Verified address notifications are sent to; empty until confirmed
+	EmailVerifiedAt         time.Time `bson:"emailVerifiedAt"` // When Email was confirmed
+	PendingEmail            string    `bson:"pendingEmail"`    // Address awaiting confirmation, see email_address.go
+	EmailVerificationSentAt time.Time `bson:"emailVerificationSentAt"`
+
+	DeletedAt time.Time `bson:"deletedAt,omitempty"` // Set while the account is in the trash, see trash.go
+}
+
+// PrivacySettings controls how a member appears to other members in the directory.
+// The zero value shows the full name and photo, matching how the directory behaved before.
+type PrivacySettings struct {
+	NameDisplay       string `bson:"nameDisplay"` // nameDisplayFull or nameDisplayInitial
+	HidePhoto         bool   `bson:"hidePhoto"`
+	HideFromDirectory bool   `bson:"hideFromDirectory"`
 }
 
+// Privacy name display options
+const (
+	nameDisplayFull    = "full"
+	nameDisplayInitial = "initial" // First name and last initial, e.g. "Alice R."
+)
+
 const usersCollection = "users" // MongoDB collection name
 
-// GetUserByID retrieves a user by their StravaID from MongoDB
+// errStravaAuthRevoked is returned when Strava no longer accepts a member's refresh token,
+// typically because they revoked the club's access from their Strava settings.
+var errStravaAuthRevoked = errors.New("strava authorization revoked")
+
+// GetUserByID retrieves a user by their StravaID from MongoDB. Accounts in the trash aren't found.
 func GetUserByID(ctx context.Context, stravaID int64) (*User, error) {
 	var user User
-	filter := bson.M{"stravaID": stravaID}
+	filter := bson.M{"stravaID": stravaID, "deletedAt": nil}
 	err := mongoDB.Collection(usersCollection).FindOne(ctx, filter).Decode(&user)
 	if err == mongo.ErrNoDocuments {
-		return nil, errors.New("user not found")
+		return nil, notFoundError("user", stravaID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user document: %w", err)
+	}
+	derivePaidStatus(&user)
+	deriveAdminStatus(&user)
+	return &user, nil
+}
+
+// GetUserByIDIncludingDeleted retrieves a user by their StravaID even if their account is in the
+// trash, e.g. to answer a subject access request during the trash retention period
+func GetUserByIDIncludingDeleted(ctx context.Context, stravaID int64) (*User, error) {
+	var user User
+	err := mongoDB.Collection(usersCollection).FindOne(ctx, bson.M{"stravaID": stravaID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("user", stravaID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user document: %w", err)
 	}
+	derivePaidStatus(&user)
+	deriveAdminStatus(&user)
 	return &user, nil
 }
 
-// CreateUser creates a new user document in MongoDB
-func CreateUser(ctx context.Context, user *User) error {
-	_, err := mongoDB.Collection(usersCollection).InsertOne(ctx, user)
+// ensureUserIndexes makes Strava IDs unique, so two logins racing can't create the same member
+// twice. Any duplicates from before are merged into the oldest document, which is the one
+// GetUserByID has been finding, and then removed.
+func ensureUserIndexes(ctx context.Context) error {
+	coll := mongoDB.Collection(usersCollection)
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$stravaID", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate users: %w", err)
+	}
+	var duplicates []struct {
+		StravaID int64 `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("failed to decode duplicate users: %w", err)
+	}
+	for _, d := range duplicates {
+		if err := mergeDuplicateUsers(ctx, d.StravaID); err != nil {
+			return err
+		}
+	}
+
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "stravaID", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user indexes: %w", err)
+	}
+	return nil
+}
+
+// mergeDuplicateUsers merges every document for a Strava ID into the oldest, then removes the
+// rest. The survivor gets all their memberships and roles, the newest tokens and last login, and
+// a verified email address if it has none.
+func mergeDuplicateUsers(ctx context.Context, stravaID int64) error {
+	coll := mongoDB.Collection(usersCollection)
+	cursor, err := coll.Find(ctx, bson.M{"stravaID": stravaID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to load duplicates of user %d: %w", stravaID, err)
+	}
+	var docs []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		User `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return fmt.Errorf("failed to decode duplicates of user %d: %w", stravaID, err)
+	}
+	if len(docs) < 2 {
+		return nil
+	}
+
+	survivor := docs[0].User
+	var removeIDs []primitive.ObjectID
+	for _, doc := range docs[1:] {
+		removeIDs = append(removeIDs, doc.ID)
+		for _, m := range doc.Memberships {
+			if !containsMembership(survivor.Memberships, m) {
+				survivor.Memberships = append(survivor.Memberships, m)
+			}
+		}
+		for _, role := range doc.Roles {
+			if !contains(survivor.Roles, role) {
+				survivor.Roles = append(survivor.Roles, role)
+			}
+		}
+		if doc.AccessTokenExp.After(survivor.AccessTokenExp) {
+			survivor.AccessToken = doc.AccessToken
+			survivor.RefreshToken = doc.RefreshToken
+			survivor.AccessTokenExp = doc.AccessTokenExp
+			survivor.StravaDisconnected = doc.StravaDisconnected
+			survivor.StravaDisconnectedAt = doc.StravaDisconnectedAt
+		}
+		if doc.LastLogin.After(survivor.LastLogin) {
+			survivor.LastLogin = doc.LastLogin
+		}
+		if survivor.Email == "" && doc.Email != "" {
+			survivor.Email = doc.Email
+			survivor.EmailVerifiedAt = doc.EmailVerifiedAt
+		}
+	}
+	derivePaidStatus(&survivor)
+
+	update := bson.M{"$set": bson.M{
+		"memberships":          survivor.Memberships,
+		"isPaidMember":         survivor.IsPaidMember,
+		"roles":                survivor.Roles,
+		"isAdmin":              contains(survivor.Roles, roleAdmin),
+		"accessToken":          survivor.AccessToken,
+		"refreshToken":         survivor.RefreshToken,
+		"accessTokenExp":       survivor.AccessTokenExp,
+		"stravaDisconnected":   survivor.StravaDisconnected,
+		"stravaDisconnectedAt": survivor.StravaDisconnectedAt,
+		"lastLogin":            survivor.LastLogin,
+		"email":                survivor.Email,
+		"emailVerifiedAt":      survivor.EmailVerifiedAt,
+	}}
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": docs[0].ID}, update); err != nil {
+		return fmt.Errorf("failed to merge duplicates of user %d: %w", stravaID, err)
+	}
+	res, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": removeIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicates of user %d: %w", stravaID, err)
+	}
+	log.Printf("Merged and removed %d duplicate documents for user %d", res.DeletedCount, stravaID)
+	return nil
+}
+
+// containsMembership reports whether memberships already has m, recorded at the same moment for
+// the same season
+func containsMembership(memberships []Membership, m Membership) bool {
+	for _, existing := range memberships {
+		if existing.Season == m.Season && existing.PaidAt.Equal(m.PaidAt) && existing.PaymentRef == m.PaymentRef {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserNames looks up the names of several users at once, including any in the trash, as other
+// members see them (see DisplayName). Users who can't be found are left out.
+func GetUserNames(ctx context.Context, stravaIDs []int64) (map[int64]string, error) {
+	names := make(map[int64]string, len(stravaIDs))
+	if len(stravaIDs) == 0 {
+		return names, nil
+	}
+	opts := options.Find().SetProjection(bson.M{"stravaID": 1, "firstName": 1, "lastName": 1, "privacy": 1})
+	cursor, err := mongoDB.Collection(usersCollection).Find(ctx, bson.M{"stravaID": bson.M{"$in": stravaIDs}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error finding user names: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error decoding user names: %w", err)
+	}
+	for _, u := range users {
+		names[u.StravaID] = u.DisplayName()
+	}
+	return names, nil
+}
+
+// UpsertUserOnLogin stores the Strava tokens from a login in a single atomic update, without
+// touching any other field, creating the user on their first login. It returns the user as they
+// are after the update and reports whether they are new. Returning users' login time is recorded
+// separately, with TouchLastLogin.
+func UpsertUserOnLogin(ctx context.Context, athlete *StravaAthlete, token *oauth2.Token, now time.Time) (*User, bool, error) {
+	user := &User{
+		StravaID:       athlete.ID,
+		FirstName:      athlete.FirstName,
+		LastName:       athlete.LastName,
+		ProfilePicURL:  athlete.Profile,
+		LastLogin:      now,
+		AccessToken:    token.AccessToken,
+		RefreshToken:   token.RefreshToken,
+		AccessTokenExp: token.Expiry,
+	}
+	login := bson.M{
+		"accessToken":          user.AccessToken,
+		"refreshToken":         user.RefreshToken,
+		"accessTokenExp":       user.AccessTokenExp,
+		"stravaDisconnected":   false,
+		"stravaDisconnectedAt": time.Time{},
+	}
+
+	// A new user is inserted with every field, zero valued, so queries on empty fields match them
+	// like any other user
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode new user %d: %w", athlete.ID, err)
+	}
+	var onInsert bson.M
+	if err := bson.Unmarshal(raw, &onInsert); err != nil {
+		return nil, false, fmt.Errorf("failed to encode new user %d: %w", athlete.ID, err)
+	}
+	delete(onInsert, "stravaID") // Taken from the filter
+	for field := range login {
+		delete(onInsert, field)
+	}
+
+	filter := bson.M{"stravaID": athlete.ID, "deletedAt": nil}
+	update := bson.M{"$set": login, "$setOnInsert": onInsert}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	var before User
+	err = mongoDB.Collection(usersCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if mongo.IsDuplicateKeyError(err) {
+		// Two logins raced to create the user and the other won, so this one updates them instead
+		err = mongoDB.Collection(usersCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	}
+	if err == mongo.ErrNoDocuments {
+		return user, true, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, false, duplicateError("user", athlete.ID, err)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record login for user %d: %w", athlete.ID, err)
+	}
+
+	if before.StravaDisconnected {
+		log.Printf("User %d reconnected Strava after revocation", before.StravaID)
+	}
+	before.AccessToken = user.AccessToken
+	before.RefreshToken = user.RefreshToken
+	before.AccessTokenExp = user.AccessTokenExp
+	before.StravaDisconnected = false
+	before.StravaDisconnectedAt = time.Time{}
+	derivePaidStatus(&before)
+	deriveAdminStatus(&before)
+	return &before, false, nil
+}
+
+// UpdateTokens stores a user's refreshed Strava tokens, leaving the rest of their document alone
+func UpdateTokens(ctx context.Context, stravaID int64, token *oauth2.Token) error {
+	filter := bson.M{"stravaID": stravaID, "deletedAt": nil}
+	update := bson.M{"$set": bson.M{
+		"accessToken":    token.AccessToken,
+		"refreshToken":   token.RefreshToken,
+		"accessTokenExp": token.Expiry,
+	}}
+	_, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
 	if err != nil {
-		return fmt.Errorf("failed to create user document: %w", err)
+		return fmt.Errorf("failed to update Strava tokens for user %d: %w", stravaID, err)
 	}
 	return nil
 }
 
-// UpdateUser updates an existing user document in MongoDB
-func UpdateUser(ctx context.Context, user *User) error {
-	filter := bson.M{"stravaID": user.StravaID}
-	update := bson.M{"$set": user}
+// TouchLastLogin records that a user logged in at t. Logins finishing out of order can't move the
+// login time backwards.
+func TouchLastLogin(ctx context.Context, stravaID int64, t time.Time) error {
+	filter := bson.M{"stravaID": stravaID, "deletedAt": nil}
+	update := bson.M{"$max": bson.M{"lastLogin": t}}
 	_, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
 	if err != nil {
-		return fmt.Errorf("failed to update user document: %w", err)
+		return fmt.Errorf("failed to update last login for user %d: %w", stravaID, err)
 	}
 	return nil
 }
 
+// SetPaidStatus marks a user paid or unpaid in a single update, reporting whether anything
+// changed. Marking them paid records m unless they already have a current membership; marking
+// them unpaid ends their running memberships. Two admins changing the same member at once can
+// therefore neither record two memberships nor undo each other's change unseen.
+func SetPaidStatus(ctx context.Context, stravaID int64, paid bool, m Membership) (bool, error) {
+	if !paid {
+		count, err := EndCurrentMembershipsForUsers(ctx, []int64{stravaID})
+		if err != nil {
+			return false, fmt.Errorf("failed to end memberships for user %d: %w", stravaID, err)
+		}
+		return count > 0, nil
+	}
+
+	now := time.Now()
+	current := bson.M{"$elemMatch": bson.M{
+		"startsAt":  bson.M{"$lte": now},
+		"expiresAt": bson.M{"$gt": now},
+	}}
+	filter := bson.M{"stravaID": stravaID, "deletedAt": nil, "memberships": bson.M{"$not": current}}
+	update := bson.M{"$push": bson.M{"memberships": m}}
+	if m.IsValidAt(now) {
+		update["$set"] = bson.M{"isPaidMember": true}
+	}
+	res, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to add membership for user %d: %w", stravaID, err)
+	}
+	return res.ModifiedCount == 1, nil
+}
+
 // GetAllUsers retrieves all users from MongoDB, ordered by firstName
 func GetAllUsers(ctx context.Context) ([]User, error) {
+	return findUsers(ctx, bson.D{})
+}
+
+// SearchUsers retrieves users whose first or last name contains query, ignoring case.
+// paid narrows the results to paid ("paid") or unpaid ("unpaid") members.
+func SearchUsers(ctx context.Context, query, paid string) ([]User, error) {
+	filter := bson.M{}
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"firstName": pattern}, bson.M{"lastName": pattern}}
+	}
+	switch paid {
+	case "paid":
+		filter["isPaidMember"] = true
+	case "unpaid":
+		filter["isPaidMember"] = false
+	}
+	return findUsers(ctx, filter)
+}
+
+// findUsers retrieves the users matching filter, sorted by first name, leaving out accounts in the trash
+func findUsers(ctx context.Context, filter interface{}) ([]User, error) {
 	var users []User
 	opts := options.Find().SetSort(bson.D{{Key: "firstName", Value: 1}})
-	cursor, err := mongoDB.Collection(usersCollection).Find(ctx, bson.D{}, opts)
+	cursor, err := mongoDB.Collection(usersCollection).Find(ctx, excludeDeleted(filter), opts)
 	if err != nil {
 		return nil, fmt.Errorf("error finding users: %w", err)
 	}
@@ -78,6 +411,8 @@ func GetAllUsers(ctx context.Context) ([]User, error) {
 		if err := cursor.Decode(&user); err != nil {
 			return nil, fmt.Errorf("error decoding user: %w", err)
 		}
+		derivePaidStatus(&user)
+		deriveAdminStatus(&user)
 		users = append(users, user)
 	}
 	if err := cursor.Err(); err != nil {
@@ -86,16 +421,102 @@ func GetAllUsers(ctx context.Context) ([]User, error) {
 	return users, nil
 }
 
-// DeleteUser deletes a user document from MongoDB
-func DeleteUser(ctx context.Context, stravaID int64) error {
+// GetUsersWithExpiringTokens retrieves connected users whose access token expires before the given time
+func GetUsersWithExpiringTokens(ctx context.Context, before time.Time) ([]User, error) {
+	var users []User
+	filter := bson.M{
+		"accessTokenExp":     bson.M{"$lt": before},
+		"refreshToken":       bson.M{"$ne": ""},
+		"stravaDisconnected": bson.M{"$ne": true},
+		"deletedAt":          nil,
+	}
+	cursor, err := mongoDB.Collection(usersCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error finding users with expiring tokens: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error decoding users with expiring tokens: %w", err)
+	}
+	return users, nil
+}
+
+// MarkStravaDisconnected flags a user as disconnected from Strava and discards their dead tokens
+func MarkStravaDisconnected(ctx context.Context, stravaID int64) error {
+	filter := bson.M{"stravaID": stravaID}
+	update := bson.M{"$set": bson.M{
+		"stravaDisconnected":   true,
+		"stravaDisconnectedAt": time.Now(),
+		"accessToken":          "",
+		"refreshToken":         "",
+	}}
+	_, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to mark user %d as Strava disconnected: %w", stravaID, err)
+	}
+	return nil
+}
+
+// UpdateUserProfile updates the Strava-sourced name and profile picture of a user
+func UpdateUserProfile(ctx context.Context, stravaID int64, firstName, lastName, profilePicURL string) error {
+	filter := bson.M{"stravaID": stravaID}
+	update := bson.M{"$set": bson.M{
+		"firstName":     firstName,
+		"lastName":      lastName,
+		"profilePicURL": profilePicURL,
+	}}
+	_, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return fmt.Errorf("failed to update profile for user %d: %w", stravaID, err)
+	}
+	return nil
+}
+
+// UpdatePrivacySettings replaces a user's directory privacy settings
+func UpdatePrivacySettings(ctx context.Context, stravaID int64, settings PrivacySettings) error {
 	filter := bson.M{"stravaID": stravaID}
-	_, err := mongoDB.Collection(usersCollection).DeleteOne(ctx, filter)
+	update := bson.M{"$set": bson.M{"privacy": settings}}
+	_, err := mongoDB.Collection(usersCollection).UpdateOne(ctx, excludeDeleted(filter), update)
 	if err != nil {
-		return fmt.Errorf("failed to delete user document with ID %d: %w", stravaID, err)
+		return fmt.Errorf("failed to update privacy settings for user %d: %w", stravaID, err)
 	}
 	return nil
 }
 
+// stravaErrorResponse is the body of an error from the Strava API
+type stravaErrorResponse struct {
+	Message string `json:"message"`
+	Errors  []struct {
+		Resource string `json:"resource"`
+		Field    string `json:"field"`
+		Code     string `json:"code"`
+	} `json:"errors"`
+}
+
+// isStravaAuthRevoked reports whether a token refresh error means the refresh token is no longer valid.
+// Strava answers a revoked refresh token with a 400 naming the refresh_token field as invalid. Any
+// other 4xx, such as a rotated client secret, is treated as transient so members keep their tokens.
+func isStravaAuthRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	if retrieveErr.ErrorCode == "invalid_grant" {
+		return true
+	}
+	var body stravaErrorResponse
+	if json.Unmarshal(retrieveErr.Body, &body) != nil {
+		return false
+	}
+	for _, e := range body.Errors {
+		if e.Field == "refresh_token" && e.Code == "invalid" {
+			return true
+		}
+	}
+	return false
+}
+
 // RefreshStravaToken attempts to refresh an expired Strava access token
 // It updates the user's document in MongoDB with the new tokens.
 func RefreshStravaToken(ctx context.Context, user *User) error {
@@ -110,6 +531,16 @@ func RefreshStravaToken(ctx context.Context, user *User) error {
 	// Request a fresh token. If the old one is expired, it will use the refresh token.
 	newToken, err := tokenSource.Token()
 	if err != nil {
+		if isStravaAuthRevoked(err) {
+			log.Printf("Strava refused to refresh token for user %d, marking as disconnected: %v", user.StravaID, err)
+			if markErr := MarkStravaDisconnected(ctx, user.StravaID); markErr != nil {
+				log.Printf("Error marking user %d as Strava disconnected: %v", user.StravaID, markErr)
+			}
+			user.StravaDisconnected = true
+			user.AccessToken = ""
+			user.RefreshToken = ""
+			return fmt.Errorf("failed to refresh Strava token for user %d: %w", user.StravaID, errStravaAuthRevoked)
+		}
 		return fmt.Errorf("failed to refresh Strava token for user %d: %w", user.StravaID, err)
 	}
 
@@ -118,7 +549,7 @@ func RefreshStravaToken(ctx context.Context, user *User) error {
 		user.AccessToken = newToken.AccessToken
 		user.RefreshToken = newToken.RefreshToken
 		user.AccessTokenExp = newToken.Expiry
-		if err := UpdateUser(ctx, user); err != nil {
+		if err := UpdateTokens(ctx, user.StravaID, newToken); err != nil {
 			return fmt.Errorf("failed to update user tokens in MongoDB after refresh: %w", err)
 		}
 		log.Printf("Successfully refreshed Strava token for user %d. New expiry: %s", user.StravaID, newToken.Expiry.Format(time.RFC3339))
@@ -131,6 +562,10 @@ func RefreshStravaToken(ctx context.Context, user *User) error {
 // Ensure token is fresh before making API calls.
 // This is a helper that tries to refresh the token if it's near expiry.
 func GetFreshStravaToken(ctx context.Context, user *User) (string, error) {
+	if user.StravaDisconnected {
+		return "", errStravaAuthRevoked
+	}
+
 	// Give a buffer for expiry (e.g., 5 minutes before actual expiry)
 	if time.Now().Add(5 * time.Minute).After(user.AccessTokenExp) {
 		log.Printf("Strava token for user %d is near expiry. Attempting refresh...", user.StravaID)
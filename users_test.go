@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/oauth2"
+)
+
+// useTestMongo points the store at a fresh database on the MongoDB in TEST_MONGODB_URI, dropping
+// it when the test ends. Tests that need MongoDB are skipped without it.
+func useTestMongo(t *testing.T) context.Context {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to test MongoDB: %v", err)
+	}
+	previous := mongoDB
+	mongoDB = client.Database(fmt.Sprintf("southpeakscc_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		if err := mongoDB.Drop(ctx); err != nil {
+			t.Errorf("dropping test database: %v", err)
+		}
+		mongoDB = previous
+		client.Disconnect(ctx)
+	})
+	if err := ensureUserIndexes(ctx); err != nil {
+		t.Fatalf("creating user indexes: %v", err)
+	}
+	return ctx
+}
+
+func testLogin(t *testing.T, ctx context.Context, stravaID int64) *User {
+	t.Helper()
+	athlete := &StravaAthlete{ID: stravaID, FirstName: "Alice", LastName: "Rider"}
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	user, _, err := UpsertUserOnLogin(ctx, athlete, token, time.Now())
+	if err != nil {
+		t.Fatalf("logging in user %d: %v", stravaID, err)
+	}
+	return user
+}
+
+func TestConcurrentLoginsCreateOneUser(t *testing.T) {
+	ctx := useTestMongo(t)
+	athlete := &StravaAthlete{ID: 101, FirstName: "Alice", LastName: "Rider"}
+
+	const logins = 10
+	var wg sync.WaitGroup
+	created := make(chan bool, logins)
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := &oauth2.Token{AccessToken: fmt.Sprintf("access-%d", i), RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+			_, isNew, err := UpsertUserOnLogin(ctx, athlete, token, time.Now())
+			if err != nil {
+				t.Errorf("login %d: %v", i, err)
+			}
+			created <- isNew
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+
+	newCount := 0
+	for isNew := range created {
+		if isNew {
+			newCount++
+		}
+	}
+	if newCount != 1 {
+		t.Errorf("%d logins created the user, want 1", newCount)
+	}
+	count, err := mongoDB.Collection(usersCollection).CountDocuments(ctx, bson.M{"stravaID": athlete.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d user documents, want 1", count)
+	}
+}
+
+// useFakeStravaTokens points the Strava OAuth config at a token endpoint answering with handler
+func useFakeStravaTokens(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	previous := stravaOAuthConf
+	stravaOAuthConf = &oauth2.Config{ClientID: "client", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+	t.Cleanup(func() {
+		stravaOAuthConf = previous
+		server.Close()
+	})
+}
+
+// A token refresh or login working from a copy of the user loaded before an admin marked them
+// paid must not write that stale copy back over the membership
+func TestStaleUserDoesNotLosePaidStatus(t *testing.T) {
+	ctx := useTestMongo(t)
+	user := testLogin(t, ctx, 102)
+	useFakeStravaTokens(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "access-refreshed", "refresh_token": "refresh-refreshed", "token_type": "Bearer", "expires_in": 21600}`)
+	})
+
+	stale, err := GetUserByID(ctx, user.StravaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newSeasonMembership(seasonFor(time.Now()), "standard", 2500, 1)
+	if changed, err := SetPaidStatus(ctx, user.StravaID, true, m); err != nil || !changed {
+		t.Fatalf("marking paid: changed %v, %v", changed, err)
+	}
+
+	stale.AccessTokenExp = time.Now().Add(-time.Minute) // Due a refresh
+	if err := RefreshStravaToken(ctx, stale); err != nil {
+		t.Fatalf("refreshing the stale user's token: %v", err)
+	}
+	athlete := &StravaAthlete{ID: stale.StravaID, FirstName: stale.FirstName, LastName: stale.LastName}
+	token := &oauth2.Token{AccessToken: "access-login", RefreshToken: "refresh-login", Expiry: time.Now().Add(time.Hour)}
+	if _, isNew, err := UpsertUserOnLogin(ctx, athlete, token, time.Now()); err != nil || isNew {
+		t.Fatalf("logging in again: new %v, %v", isNew, err)
+	}
+
+	got, err := GetUserByID(ctx, user.StravaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsPaidMember || len(got.Memberships) != 1 {
+		t.Errorf("got paid %v with memberships %+v, want the membership recorded after the user was loaded", got.IsPaidMember, got.Memberships)
+	}
+	if got.RefreshToken != "refresh-login" {
+		t.Errorf("refresh token = %q, want the one from the latest login", got.RefreshToken)
+	}
+}
+
+func TestConcurrentPaidTogglesRecordOneMembership(t *testing.T) {
+	ctx := useTestMongo(t)
+	user := testLogin(t, ctx, 103)
+
+	const admins = 5
+	var wg sync.WaitGroup
+	changed := make(chan bool, admins)
+	for i := 0; i < admins; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := newSeasonMembership(seasonFor(time.Now()), "standard", 2500, int64(i))
+			ok, err := SetPaidStatus(ctx, user.StravaID, true, m)
+			if err != nil {
+				t.Errorf("admin %d: %v", i, err)
+			}
+			changed <- ok
+		}(i)
+	}
+	wg.Wait()
+	close(changed)
+
+	changes := 0
+	for ok := range changed {
+		if ok {
+			changes++
+		}
+	}
+	if changes != 1 {
+		t.Errorf("%d admins changed the paid status, want 1", changes)
+	}
+	got, err := GetUserByID(ctx, user.StravaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Memberships) != 1 {
+		t.Errorf("got %d memberships, want 1", len(got.Memberships))
+	}
+
+	if ok, err := SetPaidStatus(ctx, user.StravaID, false, Membership{}); err != nil || !ok {
+		t.Fatalf("marking unpaid: changed %v, %v", ok, err)
+	}
+	if got, err = GetUserByID(ctx, user.StravaID); err != nil {
+		t.Fatal(err)
+	}
+	if got.IsPaidMember {
+		t.Error("still paid after marking unpaid")
+	}
+}
+
+func TestTouchLastLoginKeepsLatest(t *testing.T) {
+	ctx := useTestMongo(t)
+	user := testLogin(t, ctx, 104)
+
+	latest := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := TouchLastLogin(ctx, user.StravaID, latest.Add(-time.Duration(i)*time.Minute)); err != nil {
+				t.Errorf("touch %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := GetUserByID(ctx, user.StravaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.LastLogin.Equal(latest) {
+		t.Errorf("last login = %v, want %v", got.LastLogin, latest)
+	}
+}
+
+func TestIsStravaAuthRevoked(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"invalid grant", http.StatusBadRequest, `{"error": "invalid_grant"}`, true},
+		{"invalid refresh token", http.StatusBadRequest,
+			`{"message": "Bad Request", "errors": [{"resource": "RefreshToken", "field": "refresh_token", "code": "invalid"}]}`, true},
+		{"invalid client secret", http.StatusUnauthorized,
+			`{"message": "Bad Request", "errors": [{"resource": "Application", "field": "client_secret", "code": "invalid"}]}`, false},
+		{"missing refresh token", http.StatusBadRequest,
+			`{"message": "Bad Request", "errors": [{"resource": "RefreshToken", "field": "refresh_token", "code": "missing"}]}`, false},
+		{"rate limited", http.StatusTooManyRequests, `{"message": "Rate Limit Exceeded"}`, false},
+		{"server error", http.StatusInternalServerError, `not json`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeStravaTokens(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+			_, err := stravaOAuthConf.TokenSource(context.Background(), &oauth2.Token{RefreshToken: "refresh"}).Token()
+			if err == nil {
+				t.Fatal("token refresh succeeded, want an error")
+			}
+			if got := isStravaAuthRevoked(err); got != tt.want {
+				t.Errorf("isStravaAuthRevoked(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+
+	if isStravaAuthRevoked(fmt.Errorf("refresh: %w", context.DeadlineExceeded)) {
+		t.Error("a timeout counts as revoked access")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StravaWebhookEvent is the payload Strava POSTs for each push subscription event
+type StravaWebhookEvent struct {
+	ObjectType     string            `json:"object_type"` // "activity" or "athlete"
+	ObjectID       int64             `json:"object_id"`
+	AspectType     string            `json:"aspect_type"` // "create", "update" or "delete"
+	OwnerID        int64             `json:"owner_id"`    // Athlete ID
+	SubscriptionID int64             `json:"subscription_id"`
+	EventTime      int64             `json:"event_time"`
+	Updates        map[string]string `json:"updates"`
+}
+
+// StravaSubscription is a push subscription as returned by Strava's push_subscriptions API
+type StravaSubscription struct {
+	ID          int64  `json:"id"`
+	CallbackURL string `json:"callback_url"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// webhookEventTimeout bounds the work done for a single event after Strava has been acknowledged
+const webhookEventTimeout = 30 * time.Second
+
+// stravaWebhookHandler implements Strava's push subscription protocol.
+// GET requests validate a new subscription by echoing hub.challenge; POST requests deliver events.
+func stravaWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleStravaWebhookValidation(w, r)
+	case http.MethodPost:
+		handleStravaWebhookEvent(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// stravaWebhookValidationHandler only answers subscription validation, for before the
+// subscription's ID is configured
+func stravaWebhookValidationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Strava webhook events are not accepted until STRAVA_WEBHOOK_SUBSCRIPTION_ID is set", http.StatusForbidden)
+		return
+	}
+	handleStravaWebhookValidation(w, r)
+}
+
+// handleStravaWebhookValidation answers the callback validation request Strava sends when subscribing
+func handleStravaWebhookValidation(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("hub.mode") != "subscribe" {
+		http.Error(w, "Invalid hub.mode", http.StatusBadRequest)
+		return
+	}
+	if stravaWebhookVerifyToken == "" || query.Get("hub.verify_token") != stravaWebhookVerifyToken {
+		log.Printf("Rejected Strava webhook validation with wrong verify token")
+		http.Error(w, "Invalid verify token", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+}
+
+// handleStravaWebhookEvent acknowledges an event immediately and processes it in the background,
+// since Strava expects a 200 within two seconds and retries otherwise.
+func handleStravaWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	var event StravaWebhookEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&event); err != nil {
+		http.Error(w, "Invalid event payload", http.StatusBadRequest)
+		return
+	}
+
+	if stravaWebhookSubscriptionID == "" || strconv.FormatInt(event.SubscriptionID, 10) != stravaWebhookSubscriptionID {
+		log.Printf("Rejected Strava webhook event for unknown subscription %d", event.SubscriptionID)
+		http.Error(w, "Unknown subscription", http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if event.ObjectType != "athlete" {
+		return // Activity events are not used by the club site
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookEventTimeout)
+		defer cancel()
+		if err := processStravaAthleteEvent(ctx, event); err != nil {
+			log.Printf("Error processing Strava athlete event for %d: %v", event.OwnerID, err)
+		}
+	}()
+}
+
+// processStravaAthleteEvent marks a member deauthorized when they revoke access,
+// and refreshes their name and profile picture on any other athlete update. Events aren't signed,
+// so a deauthorization is only believed once Strava refuses the member's refresh token.
+func processStravaAthleteEvent(ctx context.Context, event StravaWebhookEvent) error {
+	user, err := GetUserByID(ctx, event.OwnerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil // Not a club member (or already deleted their account)
+		}
+		return err
+	}
+
+	if event.Updates["authorized"] == "false" {
+		if user.StravaDisconnected {
+			return nil
+		}
+		// Refreshing with an empty access token forces a refresh, which marks the member
+		// disconnected if Strava refuses it
+		check := *user
+		check.AccessToken = ""
+		err := RefreshStravaToken(ctx, &check)
+		if errors.Is(err, errStravaAuthRevoked) {
+			log.Printf("User %d revoked Strava access, marked as disconnected", user.StravaID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to confirm Strava deauthorization of user %d: %w", user.StravaID, err)
+		}
+		log.Printf("Ignoring Strava deauthorization event for user %d, whose access is still valid", user.StravaID)
+		return nil
+	}
+
+	accessToken, err := GetFreshStravaToken(ctx, user)
+	if errors.Is(err, errStravaAuthRevoked) {
+		return nil // Nothing we can fetch until they reconnect
+	}
+	if err != nil {
+		return err
+	}
+	athlete, err := getStravaAthleteDetails(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if err := UpdateUserProfile(ctx, user.StravaID, athlete.FirstName, athlete.LastName, athlete.Profile); err != nil {
+		return err
+	}
+	log.Printf("Updated Strava profile for user %d from webhook", user.StravaID)
+	return nil
+}
+
+// stravaSubscriptionsURL returns the push_subscriptions endpoint, authenticated with the app's client credentials
+func stravaSubscriptionsURL(path string) string {
+	query := url.Values{"client_id": {stravaClientID}, "client_secret": {stravaClientSecret}}
+	return stravaBaseURL + "/api/v3/push_subscriptions" + path + "?" + query.Encode()
+}
+
+// createStravaSubscription subscribes the site's webhook endpoint to Strava events.
+// Strava validates the callback synchronously, so the site must be reachable while this runs.
+func createStravaSubscription(ctx context.Context, callbackURL string) (*StravaSubscription, error) {
+	if stravaWebhookVerifyToken == "" {
+		return nil, errors.New("STRAVA_WEBHOOK_VERIFY_TOKEN must be set to create a subscription")
+	}
+	form := url.Values{
+		"client_id":     {stravaClientID},
+		"client_secret": {stravaClientSecret},
+		"callback_url":  {callbackURL},
+		"verify_token":  {stravaWebhookVerifyToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stravaBaseURL+"/api/v3/push_subscriptions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build subscription request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var sub StravaSubscription
+	if err := doStravaSubscriptionRequest(req, http.StatusCreated, &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// listStravaSubscriptions returns the app's push subscriptions (Strava allows at most one)
+func listStravaSubscriptions(ctx context.Context) ([]StravaSubscription, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, stravaSubscriptionsURL(""), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build subscription list request: %w", err)
+	}
+	var subs []StravaSubscription
+	if err := doStravaSubscriptionRequest(req, http.StatusOK, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// deleteStravaSubscription removes a push subscription
+func deleteStravaSubscription(ctx context.Context, id int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, stravaSubscriptionsURL(fmt.Sprintf("/%d", id)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build subscription delete request: %w", err)
+	}
+	return doStravaSubscriptionRequest(req, http.StatusNoContent, nil)
+}
+
+// doStravaSubscriptionRequest sends a push_subscriptions request and decodes the JSON response into out
+func doStravaSubscriptionRequest(req *http.Request, wantStatus int, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("strava subscription request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Strava has answered both 200 and 201 for creation over time, so accept either
+	if resp.StatusCode != wantStatus && !(wantStatus == http.StatusCreated && resp.StatusCode == http.StatusOK) {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("strava subscription request returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode strava subscription response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useWebhookConfig sets the webhook verify token and subscription ID for the test
+func useWebhookConfig(t *testing.T, verifyToken, subscriptionID string) {
+	t.Helper()
+	previousToken, previousID := stravaWebhookVerifyToken, stravaWebhookSubscriptionID
+	stravaWebhookVerifyToken, stravaWebhookSubscriptionID = verifyToken, subscriptionID
+	t.Cleanup(func() { stravaWebhookVerifyToken, stravaWebhookSubscriptionID = previousToken, previousID })
+}
+
+func TestStravaWebhookValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		configured  string
+		mode, token string
+		wantStatus  int
+	}{
+		{"valid", "verify-me", "subscribe", "verify-me", http.StatusOK},
+		{"wrong verify token", "verify-me", "subscribe", "guess", http.StatusForbidden},
+		{"no verify token configured", "", "subscribe", "", http.StatusForbidden},
+		{"wrong mode", "verify-me", "unsubscribe", "verify-me", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWebhookConfig(t, tt.configured, "")
+			query := url.Values{"hub.mode": {tt.mode}, "hub.verify_token": {tt.token}, "hub.challenge": {"15f7d1a91c1f40f8a748fd134752feb3"}}
+
+			// Validation works whether or not the subscription ID is configured yet
+			for _, handler := range []http.HandlerFunc{stravaWebhookHandler, stravaWebhookValidationHandler} {
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest(http.MethodGet, "/webhooks/strava?"+query.Encode(), nil))
+				if w.Code != tt.wantStatus {
+					t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+				}
+				if tt.wantStatus != http.StatusOK {
+					continue
+				}
+				var body map[string]string
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["hub.challenge"] != "15f7d1a91c1f40f8a748fd134752feb3" {
+					t.Errorf("got %v, want the challenge echoed", body)
+				}
+			}
+		})
+	}
+}
+
+func TestStravaWebhookEventNeedsSubscription(t *testing.T) {
+	// Activity events are acknowledged without any further work, so they need no database
+	event := func(subscriptionID int64) string {
+		return fmt.Sprintf(`{"object_type": "activity", "object_id": 1, "aspect_type": "create", "owner_id": 301, "subscription_id": %d}`, subscriptionID)
+	}
+	tests := []struct {
+		name       string
+		configured string
+		body       string
+		wantStatus int
+	}{
+		{"known subscription", "120475", event(120475), http.StatusOK},
+		{"other subscription", "120475", event(999), http.StatusForbidden},
+		{"no subscription in event", "120475", `{"object_type": "activity", "owner_id": 301}`, http.StatusForbidden},
+		{"no subscription configured", "", event(120475), http.StatusForbidden},
+		{"invalid payload", "120475", `not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWebhookConfig(t, "verify-me", tt.configured)
+			w := httptest.NewRecorder()
+			stravaWebhookHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/strava", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// Until the subscription ID is set, only validation is answered
+	w := httptest.NewRecorder()
+	stravaWebhookValidationHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/strava", strings.NewReader(event(120475))))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("validation-only handler: got status %d for an event, want 403", w.Code)
+	}
+}
+
+func TestDeauthorizationEventIsConfirmedWithStrava(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		body             string
+		wantDisconnected bool
+	}{
+		{"access revoked", http.StatusBadRequest, `{"error": "invalid_grant"}`, true},
+		{"access still valid", http.StatusOK,
+			`{"access_token": "access-new", "refresh_token": "refresh-new", "token_type": "Bearer", "expires_in": 21600}`, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := useTestMongo(t)
+			user := testLogin(t, ctx, int64(310+i))
+			useFakeStravaTokens(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			event := StravaWebhookEvent{ObjectType: "athlete", OwnerID: user.StravaID, AspectType: "update", Updates: map[string]string{"authorized": "false"}}
+			if err := processStravaAthleteEvent(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+			got, err := GetUserByID(ctx, user.StravaID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.StravaDisconnected != tt.wantDisconnected {
+				t.Errorf("disconnected = %v, want %v", got.StravaDisconnected, tt.wantDisconnected)
+			}
+		})
+	}
+}